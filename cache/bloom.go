@@ -0,0 +1,68 @@
+package cache
+
+// doorkeeper is a small Bloom filter in front of the frequency sketch.
+// Most keys are only ever seen once, so the sketch only starts
+// counting a key on its second occurence.
+type doorkeeper struct {
+	bits []uint64
+	k    uint32
+}
+
+// newDoorkeeper returns a Bloom filter sized for n keys with roughly a
+// 1% false positive rate.
+func newDoorkeeper(n int) *doorkeeper {
+	// m = -n ln p / (ln 2)^2 is about 9.6 bits per key for p = 0.01,
+	// with k = (m/n) ln 2, about 7 hashes.
+	m := (n*10 + 63) / 64
+	if m < 1 {
+		m = 1
+	}
+	return &doorkeeper{bits: make([]uint64, m), k: 7}
+}
+
+// pos returns the bit for the i'th hash function.
+func (d *doorkeeper) pos(h uint64, i uint32) (word int, mask uint64) {
+	p := (uint32(h) + i*uint32(h>>32)) % uint32(len(d.bits)*64)
+	return int(p / 64), 1 << (p % 64)
+}
+
+// contains reports whether the hash might have been added.
+func (d *doorkeeper) contains(h uint64) bool {
+	for i := uint32(0); i < d.k; i++ {
+		word, mask := d.pos(h, i)
+		if d.bits[word]&mask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds the hash, reporting whether it might already have been
+// there.
+func (d *doorkeeper) add(h uint64) bool {
+	present := true
+	for i := uint32(0); i < d.k; i++ {
+		word, mask := d.pos(h, i)
+		if d.bits[word]&mask == 0 {
+			present = false
+			d.bits[word] |= mask
+		}
+	}
+	return present
+}
+
+func (d *doorkeeper) reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+// hash is fnv-1a, 64 bit, inlined so it doesn't allocate.
+func hash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
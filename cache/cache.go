@@ -0,0 +1,224 @@
+// Package cache implements a W-TinyLFU cache, which uses a count-min
+// sketch of recent access frequencies to decide which items are worth
+// keeping.
+//
+// New items enter a small LRU window.  Items leaving the window compete
+// for a place in the main segmented LRU against that segment's next
+// victim, and whichever has been accessed more often recently wins.
+//
+// See "TinyLFU: A Highly Efficient Cache Admission Policy" (Gil
+// Einziger, Roy Friedman and Ben Manes, 2017)
+// https://arxiv.org/abs/1512.00727
+package cache
+
+import (
+	"container/list"
+	"fmt"
+
+	"github.com/dustin/go-probably"
+)
+
+type segment int
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+type entry struct {
+	key   string
+	value interface{}
+	seg   segment
+}
+
+// Cache is a W-TinyLFU cache holding up to a fixed number of items.
+type Cache struct {
+	capacity     int
+	windowCap    int
+	protectedCap int
+
+	items     map[string]*list.Element
+	window    *list.List
+	probation *list.List
+	protected *list.List
+
+	freq *probably.Sketch
+	door *doorkeeper
+
+	samples    int // accesses since the sketch was last aged
+	sampleSize int // accesses between agings
+}
+
+// New returns a cache that holds up to capacity items.
+func New(capacity int) *Cache {
+	if capacity < 1 {
+		panic("Capacity must be positive")
+	}
+
+	// 1% of the cache is the window, and 80% of the rest is
+	// protected, as recommended in the paper.
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+
+	w := capacity
+	if w < 64 {
+		w = 64
+	}
+
+	// The frequency sketch is aged after ten times as many accesses
+	// as there are items, which keeps its counters small, and the
+	// doorkeeper is sized to hold every key seen in that time.
+	sampleSize := 10 * capacity
+
+	return &Cache{
+		capacity:     capacity,
+		windowCap:    windowCap,
+		protectedCap: mainCap * 8 / 10,
+		items:        make(map[string]*list.Element),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		freq:         probably.NewSketch(w, 4),
+		door:         newDoorkeeper(sampleSize),
+		sampleSize:   sampleSize,
+	}
+}
+
+func (c *Cache) String() string {
+	return fmt.Sprintf("{Cache %d/%d}", len(c.items), c.capacity)
+}
+
+// Len returns the number of items in the cache.
+func (c *Cache) Len() int {
+	return len(c.items)
+}
+
+// record notes an access to the given key.
+//
+// Every access counts towards aging, including those the doorkeeper
+// keeps from the sketch, or a stream of one-off keys would fill the
+// doorkeeper without ever clearing it.
+func (c *Cache) record(key string) {
+	if c.door.add(hash(key)) {
+		c.freq.ConservativeIncrement(key)
+	}
+
+	c.samples++
+	if c.samples >= c.sampleSize {
+		c.freq.Halve()
+		c.door.reset()
+		c.samples /= 2
+	}
+}
+
+// frequency estimates how often the key has been accessed recently.
+func (c *Cache) frequency(key string) uint32 {
+	f := c.freq.Count(key)
+	if c.door.contains(hash(key)) {
+		f++
+	}
+	return f
+}
+
+// Get returns the value stored for key, if any.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.record(key)
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.touch(el)
+	return el.Value.(*entry).value, true
+}
+
+// touch moves an item that's just been accessed to the front of its
+// segment, promoting it out of probation.
+func (c *Cache) touch(el *list.Element) {
+	e := el.Value.(*entry)
+	switch e.seg {
+	case window:
+		c.window.MoveToFront(el)
+	case probation:
+		c.probation.Remove(el)
+		e.seg = protected
+		c.items[e.key] = c.protected.PushFront(e)
+
+		// Make room by demoting the least recent protected item.
+		if c.protected.Len() > c.protectedCap {
+			back := c.protected.Back()
+			demoted := c.protected.Remove(back).(*entry)
+			demoted.seg = probation
+			c.items[demoted.key] = c.probation.PushFront(demoted)
+		}
+	case protected:
+		c.protected.MoveToFront(el)
+	}
+}
+
+// Set stores value for key, possibly evicting another item.
+func (c *Cache) Set(key string, value interface{}) {
+	c.record(key)
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry).value = value
+		c.touch(el)
+		return
+	}
+
+	c.items[key] = c.window.PushFront(&entry{key, value, window})
+	if c.window.Len() <= c.windowCap {
+		return
+	}
+
+	// The window is full; its oldest item becomes a candidate for
+	// the main segment.
+	cand := c.window.Remove(c.window.Back()).(*entry)
+	cand.seg = probation
+
+	if c.probation.Len()+c.protected.Len() < c.capacity-c.windowCap {
+		c.items[cand.key] = c.probation.PushFront(cand)
+		return
+	}
+
+	victim := c.probation.Back()
+	if victim == nil {
+		victim = c.protected.Back()
+	}
+	if victim == nil {
+		delete(c.items, cand.key)
+		return
+	}
+
+	v := victim.Value.(*entry)
+	if c.frequency(cand.key) > c.frequency(v.key) {
+		c.remove(victim)
+		c.items[cand.key] = c.probation.PushFront(cand)
+	} else {
+		delete(c.items, cand.key)
+	}
+}
+
+// Delete removes key from the cache.
+func (c *Cache) Delete(key string) {
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	switch e.seg {
+	case window:
+		c.window.Remove(el)
+	case probation:
+		c.probation.Remove(el)
+	case protected:
+		c.protected.Remove(el)
+	}
+	delete(c.items, e.key)
+}
@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"container/list"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	c := New(100)
+
+	if _, ok := c.Get("hello"); ok {
+		t.Fatalf("Expected empty cache")
+	}
+
+	c.Set("hello", 1)
+	c.Set("there", 2)
+
+	if v, ok := c.Get("hello"); !ok || v != 1 {
+		t.Errorf("Expected 1 for hello, got %v, %v", v, ok)
+	}
+
+	c.Set("hello", 3)
+	if v, ok := c.Get("hello"); !ok || v != 3 {
+		t.Errorf("Expected 3 for hello, got %v, %v", v, ok)
+	}
+
+	c.Delete("there")
+	if _, ok := c.Get("there"); ok {
+		t.Errorf("Expected there to be deleted")
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("Expected 1 item, got %v", c.Len())
+	}
+}
+
+func TestCacheCapacity(t *testing.T) {
+	for _, capacity := range []int{1, 2, 10, 1000} {
+		c := New(capacity)
+		for i := 0; i < 10*capacity; i++ {
+			c.Set(strconv.Itoa(i), i)
+			if c.Len() > capacity {
+				t.Fatalf("capacity %v: holding %v items", capacity, c.Len())
+			}
+			if c.window.Len()+c.probation.Len()+c.protected.Len() != c.Len() {
+				t.Fatalf("capacity %v: segments out of sync", capacity)
+			}
+		}
+	}
+}
+
+func TestCacheScanResistance(t *testing.T) {
+	c := New(100)
+
+	// Establish a frequently used working set...
+	for n := 0; n < 10; n++ {
+		for i := 0; i < 50; i++ {
+			k := "hot" + strconv.Itoa(i)
+			if _, ok := c.Get(k); !ok {
+				c.Set(k, i)
+			}
+		}
+	}
+
+	// ...which a long scan of one-hit wonders shouldn't flush out.
+	for i := 0; i < 10000; i++ {
+		c.Set("scan"+strconv.Itoa(i), i)
+	}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, ok := c.Get("hot" + strconv.Itoa(i)); ok {
+			hits++
+		}
+	}
+	if hits < 45 {
+		t.Errorf("Expected the working set to survive the scan, %v/50 hits", hits)
+	}
+}
+
+func TestCacheDoorkeeperAging(t *testing.T) {
+	c := New(1000)
+
+	// A long scan of one-off keys mustn't fill the doorkeeper, or
+	// every new key would look like it had been seen before.
+	for i := 0; i < 200000; i++ {
+		c.Set("scan"+strconv.Itoa(i), i)
+	}
+
+	fp := 0
+	for i := 0; i < 1000; i++ {
+		if c.door.contains(hash("new" + strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	if fp > 30 {
+		t.Errorf("Expected about 1%% false positives, got %v/1000", fp)
+	}
+}
+
+// lru is a plain LRU cache to compare against.
+type lru struct {
+	capacity int
+	items    map[string]*list.Element
+	ll       *list.List
+}
+
+func newLRU(capacity int) *lru {
+	return &lru{capacity, make(map[string]*list.Element), list.New()}
+}
+
+func (c *lru) Get(key string) (interface{}, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+func (c *lru) Set(key string, value interface{}) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+	if c.ll.Len() > c.capacity {
+		delete(c.items, c.ll.Remove(c.ll.Back()).(*entry).key)
+	}
+}
+
+type getSetter interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+}
+
+// zipfTrace is a skewed access pattern, like most real workloads.
+func zipfTrace(n int) []string {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.01, 1, 1<<20)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	return trace
+}
+
+// scanTrace interleaves the skewed accesses with long sequential scans,
+// like a database mixing point lookups with batch jobs.
+func scanTrace(n int) []string {
+	trace := zipfTrace(n)
+	for i := range trace {
+		if (i/5000)%2 == 1 {
+			trace[i] = "scan" + strconv.Itoa(i)
+		}
+	}
+	return trace
+}
+
+// lazyTrace generates a trace the first time it's needed, rather than
+// on every test run.
+func lazyTrace(gen func(int) []string) func() []string {
+	var once sync.Once
+	var trace []string
+	return func() []string {
+		once.Do(func() { trace = gen(1 << 20) })
+		return trace
+	}
+}
+
+func benchmarkHitRatio(b *testing.B, c getSetter, trace []string) {
+	b.ResetTimer()
+
+	hits := 0
+	for i := 0; i < b.N; i++ {
+		k := trace[i%len(trace)]
+		if _, ok := c.Get(k); ok {
+			hits++
+		} else {
+			c.Set(k, i)
+		}
+	}
+	b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+}
+
+var (
+	zipfAccesses = lazyTrace(zipfTrace)
+	scanAccesses = lazyTrace(scanTrace)
+)
+
+func BenchmarkHitRatioZipfTinyLFU(b *testing.B) {
+	benchmarkHitRatio(b, New(1000), zipfAccesses())
+}
+
+func BenchmarkHitRatioZipfLRU(b *testing.B) {
+	benchmarkHitRatio(b, newLRU(1000), zipfAccesses())
+}
+
+func BenchmarkHitRatioScanTinyLFU(b *testing.B) {
+	benchmarkHitRatio(b, New(1000), scanAccesses())
+}
+
+func BenchmarkHitRatioScanLRU(b *testing.B) {
+	benchmarkHitRatio(b, newLRU(1000), scanAccesses())
+}
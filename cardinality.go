@@ -52,6 +52,19 @@ func NewHyperLogLog(stdErr float64) *HyperLogLog {
 	return rv
 }
 
+// hllAlpha returns the bias correction for m registers.
+func hllAlpha(m uint) float64 {
+	switch m {
+	case 16:
+		return alpha16
+	case 32:
+		return alpha32
+	case 64:
+		return alpha64
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
 // Add an item by its hash.
 func (h *HyperLogLog) Add(hash uint32) {
 	r := 1
@@ -66,30 +79,63 @@ func (h *HyperLogLog) Add(hash uint32) {
 	}
 }
 
+// hllAdd adds a hash to the given registers, reporting whether any
+// changed.
+func hllAdd(bits []uint8, kComp int, hash uint32) bool {
+	j, r := hllRank(kComp, hash)
+	if r > bits[j] {
+		bits[j] = r
+		return true
+	}
+	return false
+}
+
+// hllRank returns the register a hash belongs in, from its top bits,
+// and its rank, from the position of the lowest set bit of the rest.
+func hllRank(kComp int, hash uint32) (uint32, uint8) {
+	j := hash >> uint(kComp)
+
+	r := 1
+	for (hash&1) == 0 && r <= kComp {
+		r++
+		hash >>= 1
+	}
+	return j, uint8(r)
+}
+
 // Count returns the current estimate of the number of distinct items seen.
 func (h *HyperLogLog) Count() uint64 {
-	c := 0.0
-	for i := uint(0); i < h.m; i++ {
-		c += (1 / math.Pow(2.0, float64(h.bits[i])))
+	return hllCount(h.bits, h.alphaM)
+}
+
+// hllCount estimates the number of distinct items added to the given
+// registers.
+func hllCount(bits []uint8, alphaM float64) uint64 {
+	c, V := 0.0, 0.0
+	for _, b := range bits {
+		c += (1 / math.Pow(2.0, float64(b)))
+		if b == 0 {
+			V++
+		}
 	}
-	E := h.alphaM * float64(h.m*h.m) / c
+	return uint64(hllEstimate(float64(len(bits)), c, V, alphaM))
+}
+
+// hllEstimate estimates the number of distinct items added to m
+// registers, given the sum of 2^-register and how many are zero.
+func hllEstimate(m, c, V, alphaM float64) float64 {
+	E := alphaM * (m * m) / c
 
 	// -- make corrections
 
-	if E <= 5/2*float64(h.m) {
-		V := float64(0)
-		for i := uint(0); i < h.m; i++ {
-			if h.bits[i] == 0 {
-				V++
-			}
-		}
+	if E <= 5/2*m {
 		if V > 0 {
-			E = float64(h.m) * math.Log(float64(h.m)/V)
+			E = m * math.Log(m/V)
 		}
 	} else if E > 1/30*pow32 {
 		E = negpow32 * math.Log(1-E/pow32)
 	}
-	return uint64(E)
+	return E
 }
 
 // Merge another HyperLogLog into this one.
@@ -0,0 +1,270 @@
+package probably
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+   K-ary sketches and sketch-based change detection are described in:
+   Balachander Krishnamurthy, Subhabrata Sen, Yin Zhang and Yan Chen. 2003.
+   Sketch-based Change Detection: Methods, Evaluation, and Applications.
+   Proceedings of the 3rd ACM SIGCOMM Conference on Internet Measurement.
+   http://conferences.sigcomm.org/imc/2003/papers/p234-krishnamurthy.pdf
+*/
+
+// KArySketch is a k-ary sketch.  Unlike a count-min sketch, its
+// estimates are unbiased and it's linear: sketches can be scaled,
+// added and subtracted, which makes it possible to forecast and
+// compare whole intervals at once.
+type KArySketch struct {
+	w, d int
+	sk   []float64
+	sum  float64
+}
+
+// NewKArySketch returns a new k-ary sketch with the given width and
+// depth.  The width must be at least two.
+func NewKArySketch(w, d int) *KArySketch {
+	if d < 1 || w < 2 {
+		panic("Dimensions must be positive, and width at least two")
+	}
+
+	return &KArySketch{
+		w:  w,
+		d:  d,
+		sk: make([]float64, w*d),
+	}
+}
+
+func (s KArySketch) String() string {
+	return fmt.Sprintf("{KArySketch %dx%d}", s.w, s.d)
+}
+
+func (s *KArySketch) index(i int, h1, h2 uint32) int {
+	return i*s.w + int((h1+uint32(i)*h2)%uint32(s.w))
+}
+
+// Update adds v to the given input's value.
+func (s *KArySketch) Update(h string, v float64) {
+	h1, h2 := hashn(h)
+	for i := 0; i < s.d; i++ {
+		s.sk[s.index(i, h1, h2)] += v
+	}
+	s.sum += v
+}
+
+// Estimate returns the estimated value for the given input.
+func (s *KArySketch) Estimate(h string) float64 {
+	var buf [maxStackDepth]float64
+	ests := buf[:0]
+	if s.d > maxStackDepth {
+		ests = make([]float64, 0, s.d)
+	}
+
+	// Each row's counter holds the input's value plus, on average,
+	// 1/w of everything else.
+	w := float64(s.w)
+	h1, h2 := hashn(h)
+	for i := 0; i < s.d; i++ {
+		v := s.sk[s.index(i, h1, h2)]
+		ests = append(ests, (v-s.sum/w)/(1-1/w))
+	}
+	return medianf(ests)
+}
+
+// Sum returns the sum of all values added.
+func (s *KArySketch) Sum() float64 {
+	return s.sum
+}
+
+// Scale multiplies every value by c.
+func (s *KArySketch) Scale(c float64) {
+	for i := range s.sk {
+		s.sk[i] *= c
+	}
+	s.sum *= c
+}
+
+// AddScaled adds c times the values of the given sketch, which must
+// have the same dimensions, to this one.  Use a negative c to subtract.
+func (s *KArySketch) AddScaled(from *KArySketch, c float64) {
+	if s.w != from.w || s.d != from.d {
+		panic("Can't combine sketches with different dimensions")
+	}
+	for i, v := range from.sk {
+		s.sk[i] += c * v
+	}
+	s.sum += c * from.sum
+}
+
+// Clone returns a copy of this sketch.
+func (s *KArySketch) Clone() *KArySketch {
+	clone := NewKArySketch(s.w, s.d)
+	copy(clone.sk, s.sk)
+	clone.sum = s.sum
+	return clone
+}
+
+// Change is a key whose value in an interval differed from the
+// forecast.
+type Change struct {
+	Key      string
+	Observed float64
+	Forecast float64
+	Error    float64
+}
+
+// ChangeDetector reports keys whose frequency in an interval differs
+// sharply from what the previous intervals predicted.
+//
+// Observations for each interval go into a k-ary sketch.  At the end
+// of the interval it is compared against a forecast sketch built from
+// the previous intervals, either as a moving average or as an
+// exponentially weighted moving average.  The sketches can't name
+// keys by themselves, so the most frequent keys of the current and
+// previous intervals are tracked as candidates, StreamTop-style.
+type ChangeDetector struct {
+	w, d      int
+	maxItems  int
+	threshold float64
+
+	alpha   float64         // EWMA weight, if using EWMA
+	window  int             // moving average length, if not
+	history []*KArySketch   // the last window intervals, for MA
+	fcast   *KArySketch     // nil until an interval has ended
+	cur     *KArySketch     // the interval being observed
+	keys    map[string]bool // candidates from the current interval
+	prev    map[string]bool // candidates from the previous interval
+}
+
+// NewEWMAChangeDetector returns a change detector that forecasts each
+// interval as the exponentially weighted moving average of the
+// previous ones, giving the latest interval weight alpha.  Changes
+// whose absolute error is at least threshold are reported.  Up to
+// maxItems candidate keys are tracked per interval, using k-ary
+// sketches of the given width and depth.
+func NewEWMAChangeDetector(w, d, maxItems int, alpha, threshold float64) *ChangeDetector {
+	if alpha <= 0 || alpha > 1 {
+		panic("alpha must be in (0, 1]")
+	}
+	c := newChangeDetector(w, d, maxItems, threshold)
+	c.alpha = alpha
+	return c
+}
+
+// NewMAChangeDetector returns a change detector that forecasts each
+// interval as the mean of the previous window intervals.  See
+// NewEWMAChangeDetector for the other parameters.
+func NewMAChangeDetector(w, d, maxItems, window int, threshold float64) *ChangeDetector {
+	if window < 1 {
+		panic("window must be positive")
+	}
+	c := newChangeDetector(w, d, maxItems, threshold)
+	c.window = window
+	return c
+}
+
+func newChangeDetector(w, d, maxItems int, threshold float64) *ChangeDetector {
+	return &ChangeDetector{
+		w:         w,
+		d:         d,
+		maxItems:  maxItems,
+		threshold: threshold,
+		cur:       NewKArySketch(w, d),
+		keys:      map[string]bool{},
+		prev:      map[string]bool{},
+	}
+}
+
+// Observe adds count occurences of key to the current interval.
+func (c *ChangeDetector) Observe(key string, count float64) {
+	c.cur.Update(key, count)
+	c.keys[key] = true
+	if len(c.keys) > c.maxItems {
+		c.trim()
+	}
+}
+
+// trim keeps the three quarters of the candidates that the current
+// interval's sketch estimates are most frequent.
+func (c *ChangeDetector) trim() {
+	type cand struct {
+		key string
+		est float64
+	}
+	cands := make([]cand, 0, len(c.keys))
+	for k := range c.keys {
+		cands = append(cands, cand{k, c.cur.Estimate(k)})
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		return cands[i].est > cands[j].est
+	})
+	for _, x := range cands[int(float64(c.maxItems)*0.75):] {
+		delete(c.keys, x.key)
+	}
+}
+
+// EndInterval closes the current interval and returns the candidate
+// keys whose forecast error is at least the threshold, largest errors
+// first.  Nothing is reported for the first interval, as there's no
+// forecast yet.
+func (c *ChangeDetector) EndInterval() []Change {
+	var rv []Change
+
+	if c.fcast != nil {
+		errs := c.cur.Clone()
+		errs.AddScaled(c.fcast, -1)
+
+		check := func(k string) {
+			if e := errs.Estimate(k); math.Abs(e) >= c.threshold {
+				rv = append(rv, Change{k, c.cur.Estimate(k), c.fcast.Estimate(k), e})
+			}
+		}
+		for k := range c.keys {
+			check(k)
+		}
+		// Keys that were busy last time but vanished are changes
+		// too.
+		for k := range c.prev {
+			if !c.keys[k] {
+				check(k)
+			}
+		}
+
+		sort.Slice(rv, func(i, j int) bool {
+			return math.Abs(rv[i].Error) > math.Abs(rv[j].Error)
+		})
+	}
+
+	c.forecast()
+
+	c.prev, c.keys = c.keys, map[string]bool{}
+	c.cur = NewKArySketch(c.w, c.d)
+
+	return rv
+}
+
+// forecast folds the interval that just ended into the forecast for
+// the next one.
+func (c *ChangeDetector) forecast() {
+	if c.window > 0 {
+		c.history = append(c.history, c.cur)
+		if len(c.history) > c.window {
+			c.history = c.history[1:]
+		}
+		c.fcast = NewKArySketch(c.w, c.d)
+		for _, s := range c.history {
+			c.fcast.AddScaled(s, 1/float64(len(c.history)))
+		}
+		return
+	}
+
+	if c.fcast == nil {
+		c.fcast = c.cur.Clone()
+		return
+	}
+	c.fcast.Scale(1 - c.alpha)
+	c.fcast.AddScaled(c.cur, c.alpha)
+}
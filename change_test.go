@@ -0,0 +1,74 @@
+package probably
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestKArySketch(t *testing.T) {
+	s := NewKArySketch(1024, 5)
+
+	for i := 0; i < 1000; i++ {
+		s.Update(strconv.Itoa(i), 1)
+	}
+	s.Update("hello", 100)
+
+	if got := s.Estimate("hello"); math.Abs(got-100) > 5 {
+		t.Errorf("Expected about 100 for hello, got %v", got)
+	}
+	if got := s.Estimate("world"); math.Abs(got) > 5 {
+		t.Errorf("Expected about 0 for world, got %v", got)
+	}
+
+	other := s.Clone()
+	other.Update("hello", 50)
+	other.AddScaled(s, -1)
+	if got := other.Estimate("hello"); math.Abs(got-50) > 1e-9 {
+		t.Errorf("Expected 50 for the difference, got %v", got)
+	}
+	if other.Sum() != 50 {
+		t.Errorf("Expected difference sum 50, got %v", other.Sum())
+	}
+}
+
+func testChangeDetector(t *testing.T, c *ChangeDetector) {
+	observe := func(spike, drop bool) {
+		for i := 0; i < 200; i++ {
+			c.Observe(strconv.Itoa(i), 10)
+		}
+		if spike {
+			c.Observe("hello", 500)
+		}
+		if !drop {
+			c.Observe("there", 300)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		observe(false, false)
+		if changes := c.EndInterval(); len(changes) != 0 {
+			t.Fatalf("Expected no changes in steady interval %v, got %v", i, changes)
+		}
+	}
+
+	observe(true, true)
+	changes := c.EndInterval()
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+	if changes[0].Key != "hello" || changes[0].Error < 450 {
+		t.Errorf("Expected hello to jump by about 500, got %+v", changes[0])
+	}
+	if changes[1].Key != "there" || changes[1].Error > -250 {
+		t.Errorf("Expected there to drop by about 300, got %+v", changes[1])
+	}
+}
+
+func TestEWMAChangeDetector(t *testing.T) {
+	testChangeDetector(t, NewEWMAChangeDetector(1024, 5, 100, 0.5, 100))
+}
+
+func TestMAChangeDetector(t *testing.T) {
+	testChangeDetector(t, NewMAChangeDetector(1024, 5, 100, 3, 100))
+}
@@ -0,0 +1,148 @@
+package probably
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// ConcurrentSketch is a count-min sketch that can be safely updated
+// and queried from many goroutines at once.
+//
+// Every counter is updated with atomic operations, so a single large
+// sketch can be shared by all workers instead of giving each its own
+// Sketch to Merge later.  Queries that race with updates see some
+// consistent value for each counter, but not necessarily the same
+// moment across rows.
+type ConcurrentSketch struct {
+	w, d      int
+	sk        []uint32
+	rowCounts []uint32
+}
+
+// NewConcurrentSketch returns a new concurrent count-min sketch with
+// the given width and depth.
+func NewConcurrentSketch(w, d int) *ConcurrentSketch {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+
+	return &ConcurrentSketch{
+		w:         w,
+		d:         d,
+		sk:        make([]uint32, w*d),
+		rowCounts: make([]uint32, d),
+	}
+}
+
+func (s *ConcurrentSketch) String() string {
+	return fmt.Sprintf("{ConcurrentSketch %dx%d}", s.w, s.d)
+}
+
+func (s *ConcurrentSketch) index(i int, h1, h2 uint32) int {
+	return i*s.w + int((h1+uint32(i)*h2)%uint32(s.w))
+}
+
+// Add 'count' occurences of the given input
+func (s *ConcurrentSketch) Add(h string, count uint32) (val uint32) {
+	val = math.MaxUint32
+	h1, h2 := hashn(h)
+	for i := 0; i < s.d; i++ {
+		atomic.AddUint32(&s.rowCounts[i], count)
+		v := atomic.AddUint32(&s.sk[s.index(i, h1, h2)], count)
+		if v < val {
+			val = v
+		}
+	}
+	return val
+}
+
+// Increment the count for the given input.
+func (s *ConcurrentSketch) Increment(h string) (val uint32) {
+	return s.Add(h, 1)
+}
+
+// ConservativeIncrement increments the count (conservatively) for the given input.
+func (s *ConcurrentSketch) ConservativeIncrement(h string) (val uint32) {
+	return s.ConservativeAdd(h, 1)
+}
+
+// ConservativeAdd adds the count (conservatively) for the given input.
+//
+// The counters are read, then each one below the new count is raised
+// with a compare-and-swap from the value that was read.  If any of
+// them changed in the meantime, the minimum may be stale, so the
+// update starts over rather than risk losing a concurrent increment.
+// A retried update may leave some counters higher than a sequential
+// one would, but it never underestimates.
+func (s *ConcurrentSketch) ConservativeAdd(h string, count uint32) (val uint32) {
+	var buf [maxStackDepth]uint32
+	seen := buf[:]
+	if s.d > maxStackDepth {
+		seen = make([]uint32, s.d)
+	}
+	seen = seen[:s.d]
+
+	h1, h2 := hashn(h)
+	for {
+		val = s.observe(h1, h2, seen) + count
+		if s.raise(h1, h2, seen, val) {
+			return val
+		}
+	}
+}
+
+// observe reads the counters for the given hash into seen, and returns
+// the smallest.
+func (s *ConcurrentSketch) observe(h1, h2 uint32, seen []uint32) uint32 {
+	min := uint32(math.MaxUint32)
+	for i := range seen {
+		v := atomic.LoadUint32(&s.sk[s.index(i, h1, h2)])
+		seen[i] = v
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// raise sets the counters below val to val, as long as they still hold
+// what was seen, and reports whether they all did.
+func (s *ConcurrentSketch) raise(h1, h2 uint32, seen []uint32, val uint32) bool {
+	for i, v := range seen {
+		if v >= val {
+			continue
+		}
+		if !atomic.CompareAndSwapUint32(&s.sk[s.index(i, h1, h2)], v, val) {
+			return false
+		}
+		atomic.AddUint32(&s.rowCounts[i], val-v)
+	}
+	return true
+}
+
+// Count returns the estimated count for the given input.
+func (s *ConcurrentSketch) Count(h string) uint32 {
+	min := uint32(math.MaxUint32)
+	h1, h2 := hashn(h)
+	for i := 0; i < s.d; i++ {
+		v := atomic.LoadUint32(&s.sk[s.index(i, h1, h2)])
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// Snapshot returns a copy of this sketch as a regular Sketch, which
+// can then be merged, compressed, and so on.
+func (s *ConcurrentSketch) Snapshot() *Sketch {
+	rv := NewSketch(s.w, s.d)
+	for i := range rv.sk {
+		rv.sk[i] = atomic.LoadUint32(&s.sk[i])
+	}
+	for i := range rv.rowCounts {
+		rv.rowCounts[i] = atomic.LoadUint32(&s.rowCounts[i])
+	}
+	return rv
+}
@@ -0,0 +1,133 @@
+package probably
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCounting(t *testing.T) {
+	s := NewConcurrentSketch(1024, 4)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Increment("hello")
+				s.ConservativeIncrement("there")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := s.Count("hello"); got != 8000 {
+		t.Errorf("Expected 8000 for hello, got %v", got)
+	}
+	if got := s.Count("there"); got < 8000 {
+		t.Errorf("Expected at least 8000 for there, got %v", got)
+	}
+	if got := s.Count("world"); got != 0 {
+		t.Errorf("Expected 0 for world, got %v", got)
+	}
+
+	snap := s.Snapshot()
+	for _, k := range []string{"hello", "there", "world"} {
+		if snap.Count(k) != s.Count(k) {
+			t.Errorf("Snapshot disagrees for %v: %v != %v",
+				k, snap.Count(k), s.Count(k))
+		}
+	}
+}
+
+func TestConcurrentConservativeRace(t *testing.T) {
+	s := NewConcurrentSketch(1024, 4)
+	h1, h2 := hashn("there")
+
+	// Two updates see the same counters, and the first one wins.  The
+	// second must notice rather than count as the same increment.
+	a, b := make([]uint32, 4), make([]uint32, 4)
+	va := s.observe(h1, h2, a) + 1
+	vb := s.observe(h1, h2, b) + 1
+	if !s.raise(h1, h2, a, va) {
+		t.Fatalf("Expected the first update to succeed")
+	}
+	if s.raise(h1, h2, b, vb) {
+		t.Fatalf("Expected the second update to see the counters change")
+	}
+	s.ConservativeIncrement("there")
+	if got := s.Count("there"); got < 2 {
+		t.Errorf("Expected at least 2, got %v", got)
+	}
+}
+
+func TestConcurrentConservativeContention(t *testing.T) {
+	s := NewConcurrentSketch(1024, 4)
+
+	const goroutines, n = 16, 20000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				s.ConservativeIncrement("there")
+				if i%64 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := s.Count("there"); got < goroutines*n {
+		t.Errorf("Expected at least %v, got %v", goroutines*n, got)
+	}
+}
+
+var benchKeys = func() []string {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func BenchmarkConcurrentSketchShared(b *testing.B) {
+	s := NewConcurrentSketch(1<<20, 5)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.ConservativeIncrement(benchKeys[i%len(benchKeys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkConcurrentSketchPerWorkerMerge(b *testing.B) {
+	workers := runtime.GOMAXPROCS(0)
+	sketches := make([]*Sketch, workers)
+	for i := range sketches {
+		sketches[i] = NewSketch(1<<20, 5)
+	}
+
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(s *Sketch, n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				s.ConservativeIncrement(benchKeys[i%len(benchKeys)])
+			}
+		}(sketches[w], b.N/workers+1)
+	}
+	wg.Wait()
+
+	for _, s := range sketches[1:] {
+		sketches[0].Merge(s)
+	}
+}
@@ -2,15 +2,38 @@ package probably
 
 import (
 	"fmt"
-	"hash/fnv"
 	"math"
-	"sort"
 )
 
+// Estimator selects how a Sketch turns an input's counters into a
+// count.
+type Estimator int
+
+const (
+	// Min is the classic count-min estimate: the smallest counter.
+	// It never underestimates.
+	Min Estimator = iota
+	// MeanMin subtracts each row's expected collision noise before
+	// taking the median.  See CountMeanMin.
+	MeanMin
+	// Median is the median of the counters.
+	Median
+)
+
+// Above this depth, estimators that need to sort the counters have
+// to allocate.
+const maxStackDepth = 32
+
 // Sketch is a count-min sketcher.
 type Sketch struct {
-	sk        [][]uint32
+	w, d      int
+	sk        []uint32 // d rows of w counters, one after another
 	rowCounts []uint32
+	est       Estimator
+	fixed     bool // the width can't change, e.g. when memory-mapped
+
+	resetAfter uint64
+	additions  uint64
 }
 
 // NewSketch returns new count-min sketch with the given width and depth.
@@ -21,20 +44,26 @@ func NewSketch(w, d int) *Sketch {
 		panic("Dimensions must be positive")
 	}
 
-	s := &Sketch{}
-
-	s.sk = make([][]uint32, d)
-	for i := 0; i < d; i++ {
-		s.sk[i] = make([]uint32, w)
+	return &Sketch{
+		w:         w,
+		d:         d,
+		sk:        make([]uint32, w*d),
+		rowCounts: make([]uint32, d),
 	}
+}
 
-	s.rowCounts = make([]uint32, d)
+func (s Sketch) String() string {
+	return fmt.Sprintf("{Sketch %dx%d}", s.w, s.d)
+}
 
-	return s
+// index returns the position in sk of the input's counter in row i.
+func (s *Sketch) index(i int, h1, h2 uint32) int {
+	return i*s.w + int((h1+uint32(i)*h2)%uint32(s.w))
 }
 
-func (s Sketch) String() string {
-	return fmt.Sprintf("{Sketch %dx%d}", len(s.sk[0]), len(s.sk))
+// row returns the counters of row i.
+func (s *Sketch) row(i int) []uint32 {
+	return s.sk[i*s.w : (i+1)*s.w]
 }
 
 func hashn(s string) (h1, h2 uint32) {
@@ -50,9 +79,12 @@ func hashn(s string) (h1, h2 uint32) {
 
 	// TODO(dgryski): Switch to something that is actually validated by the literature.
 
-	fnv1a := fnv.New32a()
-	fnv1a.Write([]byte(s))
-	h1 = fnv1a.Sum32()
+	// inlined fnv-1a, so hashing doesn't allocate
+	h1 = 2166136261
+	for i := 0; i < len(s); i++ {
+		h1 ^= uint32(s[i])
+		h1 *= 16777619
+	}
 
 	// inlined jenkins one-at-a-time hash
 	h2 = uint32(0)
@@ -68,53 +100,130 @@ func hashn(s string) (h1, h2 uint32) {
 	return h1, h2
 }
 
+// Key is a precomputed hash of an input.  Hashing once and reusing the
+// Key saves work when the same input is added to several sketches or
+// queried repeatedly.
+type Key struct {
+	h1, h2 uint32
+}
+
+// NewKey returns the Key for the given input.
+func NewKey(h string) Key {
+	h1, h2 := hashn(h)
+	return Key{h1, h2}
+}
+
 // Reset clears all the values from the sketch.
 func (s *Sketch) Reset() {
-
-	// Complier doesn't yet optimize this into memset: https://code.google.com/p/go/issues/detail?id=5373
-	for _, w := range s.sk {
-		for i := range w {
-			w[i] = 0
-		}
+	for i := range s.sk {
+		s.sk[i] = 0
 	}
 
 	for i := range s.rowCounts {
 		s.rowCounts[i] = 0
 	}
+
+	s.additions = 0
+}
+
+/*
+   Halving every counter after a fixed number of additions is the
+   "reset" operation from:
+   Gil Einziger, Roy Friedman and Ben Manes. 2017. TinyLFU: A Highly
+   Efficient Cache Admission Policy. ACM Transactions on Storage 13(4).
+   https://arxiv.org/abs/1512.00727
+*/
+
+// Halve divides every counter by two, so older occurences count for
+// less than recent ones.
+func (s *Sketch) Halve() {
+	for i := range s.sk {
+		s.sk[i] >>= 1
+	}
+	s.sumRows()
+}
+
+// Scale multiplies every counter by factor, which must be between 0
+// and 1.
+func (s *Sketch) Scale(factor float64) {
+	if factor < 0 || factor > 1 {
+		panic("scale factor must be between 0 and 1")
+	}
+	for i, v := range s.sk {
+		s.sk[i] = uint32(float64(v) * factor)
+	}
+	s.sumRows()
+}
+
+// sumRows recomputes the row totals after every counter has changed,
+// since rounding each counter down isn't the same as scaling the total.
+func (s *Sketch) sumRows() {
+	for i := range s.rowCounts {
+		var sum uint32
+		for _, v := range s.row(i) {
+			sum += v
+		}
+		s.rowCounts[i] = sum
+	}
+}
+
+// SetResetAfter makes the sketch Halve itself after every n
+// occurences have been added, keeping the counters bounded and biased
+// towards recent activity.  Zero, the default, never halves.
+func (s *Sketch) SetResetAfter(n uint64) {
+	s.resetAfter = n
+}
+
+// age counts the occurences just added, halving the sketch when it's
+// time.  It returns val adjusted for any halving.
+func (s *Sketch) age(count uint32, val uint32) uint32 {
+	if s.resetAfter == 0 {
+		return val
+	}
+	s.additions += uint64(count)
+	if s.additions >= s.resetAfter {
+		s.Halve()
+		s.additions /= 2
+		val >>= 1
+	}
+	return val
 }
 
 // Add 'count' occurences of the given input
 func (s *Sketch) Add(h string, count uint32) (val uint32) {
-	w := len(s.sk[0])
-	d := len(s.sk)
+	return s.AddKey(NewKey(h), count)
+}
+
+// AddKey adds 'count' occurences of the input with the given Key.
+func (s *Sketch) AddKey(k Key, count uint32) (val uint32) {
+	d := s.d
 	val = math.MaxUint32
-	h1, h2 := hashn(h)
+	h1, h2 := k.h1, k.h2
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
+		pos := s.index(i, h1, h2)
 		s.rowCounts[i] += count
-		v := s.sk[i][pos] + count
-		s.sk[i][pos] = v
+		v := s.sk[pos] + count
+		s.sk[pos] = v
 		if v < val {
 			val = v
 		}
 	}
-	return val
+	return s.age(count, val)
 }
 
 // Del removes 'count' occurences of the given input
 func (s *Sketch) Del(h string, count uint32) (val uint32) {
-	w := len(s.sk[0])
-	d := len(s.sk)
+	d := s.d
 	val = math.MaxUint32
 	h1, h2 := hashn(h)
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
+		pos := s.index(i, h1, h2)
 		s.rowCounts[i] -= count
-		v := s.sk[i][pos] - count
-		if v > s.sk[i][pos] { // did we wrap-around?
+		v := s.sk[pos] - count
+		if v > s.sk[pos] { // did we wrap-around?
 			v = 0
 		}
-		s.sk[i][pos] = v
+		s.sk[pos] = v
 		if v < val {
 			val = v
 		}
@@ -134,14 +243,19 @@ func (s *Sketch) ConservativeIncrement(h string) (val uint32) {
 
 // ConservativeAdd adds the count (conservatively) for the given input.
 func (s *Sketch) ConservativeAdd(h string, count uint32) (val uint32) {
-	w := len(s.sk[0])
-	d := len(s.sk)
-	h1, h2 := hashn(h)
+	return s.ConservativeAddKey(NewKey(h), count)
+}
+
+// ConservativeAddKey adds the count (conservatively) for the input
+// with the given Key.
+func (s *Sketch) ConservativeAddKey(k Key, count uint32) (val uint32) {
+	d := s.d
+	h1, h2 := k.h1, k.h2
 	val = math.MaxUint32
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
+		pos := s.index(i, h1, h2)
 
-		v := s.sk[i][pos]
+		v := s.sk[pos]
 		if v < val {
 			val = v
 		}
@@ -155,27 +269,46 @@ func (s *Sketch) ConservativeAdd(h string, count uint32) (val uint32) {
 	// traffic measurement and accounting. SIGCOMM Comput. Commun. Rev., 32(4).
 
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
-		v := s.sk[i][pos]
+		pos := s.index(i, h1, h2)
+		v := s.sk[pos]
 		if v < val {
-			s.rowCounts[i] += (val - s.sk[i][pos])
-			s.sk[i][pos] = val
+			s.rowCounts[i] += (val - s.sk[pos])
+			s.sk[pos] = val
 		}
 	}
-	return val
+	return s.age(count, val)
+}
+
+// SetEstimator selects the estimator used by Count.  The default is Min.
+func (s *Sketch) SetEstimator(e Estimator) {
+	s.est = e
 }
 
 // Count returns the estimated count for the given input.
 func (s Sketch) Count(h string) uint32 {
+	return s.CountKey(NewKey(h))
+}
+
+// CountKey returns the estimated count for the input with the given Key.
+func (s Sketch) CountKey(k Key) uint32 {
+	switch s.est {
+	case MeanMin:
+		return s.countMeanMin(k)
+	case Median:
+		return s.countMedian(k)
+	}
+	return s.countMin(k)
+}
+
+func (s Sketch) countMin(k Key) uint32 {
 	min := uint32(math.MaxUint32)
-	w := len(s.sk[0])
-	d := len(s.sk)
+	d := s.d
 
-	h1, h2 := hashn(h)
+	h1, h2 := k.h1, k.h2
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
+		pos := s.index(i, h1, h2)
 
-		v := s.sk[i][pos]
+		v := s.sk[pos]
 		if v < min {
 			min = v
 		}
@@ -183,18 +316,34 @@ func (s Sketch) Count(h string) uint32 {
 	return min
 }
 
+// AddBatch adds 'count' occurences of each of the given inputs.
+func (s *Sketch) AddBatch(hs []string, count uint32) {
+	for _, h := range hs {
+		s.AddKey(NewKey(h), count)
+	}
+}
+
+// CountBatch appends the estimated count for each of the given inputs
+// to dst and returns the extended slice.  Passing a dst with enough
+// capacity avoids any allocation.
+func (s Sketch) CountBatch(hs []string, dst []uint32) []uint32 {
+	for _, h := range hs {
+		dst = append(dst, s.CountKey(NewKey(h)))
+	}
+	return dst
+}
+
 // Values returns the all the estimates for a given string
 func (s Sketch) Values(h string) []uint32 {
-	w := len(s.sk[0])
-	d := len(s.sk)
+	d := s.d
 
 	vals := make([]uint32, d)
 
 	h1, h2 := hashn(h)
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
+		pos := s.index(i, h1, h2)
 
-		vals[i] = s.sk[i][pos]
+		vals[i] = s.sk[pos]
 	}
 
 	return vals
@@ -217,35 +366,42 @@ func (s Sketch) Values(h string) []uint32 {
 // under-estimation, use the regular Count() and only call ConservativeAdd()
 // and ConservativeIncrement() when constructing your sketch.
 func (s Sketch) CountMeanMin(h string) uint32 {
+	return s.countMeanMin(NewKey(h))
+}
+
+func (s Sketch) countMeanMin(k Key) uint32 {
 	min := uint32(math.MaxUint32)
-	w := len(s.sk[0])
-	d := len(s.sk)
-	residues := make([]float64, d)
-	h1, h2 := hashn(h)
+	w := s.w
+	d := s.d
+
+	// With a single column there's no way to tell noise from signal.
+	if w == 1 {
+		return s.countMin(k)
+	}
+
+	var buf [maxStackDepth]float64
+	residues := buf[:0]
+	if d > maxStackDepth {
+		residues = make([]float64, 0, d)
+	}
+
+	h1, h2 := k.h1, k.h2
 	for i := 0; i < d; i++ {
-		pos := (h1 + uint32(i)*h2) % uint32(w)
-		v := s.sk[i][pos]
-		noise := float64(s.rowCounts[i]-s.sk[i][pos]) / float64(w-1)
-		residues[i] = float64(v) - noise
+		pos := s.index(i, h1, h2)
+		v := s.sk[pos]
+		noise := float64(s.rowCounts[i]-v) / float64(w-1)
+		r := float64(v) - noise
 		// negative count doesn't make sense
-		if residues[i] < 0 {
-			residues[i] = 0
+		if r < 0 {
+			r = 0
 		}
+		residues = append(residues, r)
 		if v < min {
 			min = v
 		}
 	}
 
-	sort.Float64s(residues)
-	var median uint32
-	if d%2 == 1 {
-		median = uint32(residues[(d+1)/2])
-	} else {
-		// integer average without overflow
-		x := uint32(residues[d/2])
-		y := uint32(residues[d/2+1])
-		median = (x & y) + (x^y)/2
-	}
+	median := uint32(medianf(residues))
 
 	// count estimate over the upper-bound (min) doesn't make sense
 	if min < median {
@@ -255,33 +411,110 @@ func (s Sketch) CountMeanMin(h string) uint32 {
 	return median
 }
 
+func (s Sketch) countMedian(k Key) uint32 {
+	d := s.d
+
+	var buf [maxStackDepth]float64
+	vals := buf[:0]
+	if d > maxStackDepth {
+		vals = make([]float64, 0, d)
+	}
+
+	h1, h2 := k.h1, k.h2
+	for i := 0; i < d; i++ {
+		vals = append(vals, float64(s.sk[s.index(i, h1, h2)]))
+	}
+
+	return uint32(medianf(vals))
+}
+
+// medianf returns the median of vals, sorting them in place.  An
+// insertion sort is plenty for a sketch's depth, and unlike
+// sort.Float64s it doesn't make vals escape.
+func medianf(vals []float64) float64 {
+	for i := 1; i < len(vals); i++ {
+		for j := i; j > 0 && vals[j] < vals[j-1]; j-- {
+			vals[j], vals[j-1] = vals[j-1], vals[j]
+		}
+	}
+
+	n := len(vals)
+	if n%2 == 1 {
+		return vals[n/2]
+	}
+	return (vals[n/2-1] + vals[n/2]) / 2
+}
+
+/*
+   Linear counting described in:
+   Kyu-Young Whang, Brad T. Vander-Zanden and Howard M. Taylor. 1990.
+   A linear-time probabilistic counting algorithm for database applications.
+   ACM Transactions on Database Systems 15(2).
+*/
+
+// DistinctEstimate returns the estimated number of distinct inputs
+// added to the sketch, from the fraction of counters still at zero.
+//
+// Each row is a linear counting bitmap of width w, and the rows are
+// pooled.  With t = n/w, the standard error of a single row is
+// sqrt(w(ℯ^t-t-1))/n: about 1% for n = w = 100000, rising quickly once
+// n is several times w.  When no counter is left at zero, the rows
+// are saturated and the estimate is pinned at w·ln(w·d).
+func (s Sketch) DistinctEstimate() uint64 {
+	zeros := 0
+	for _, v := range s.sk {
+		if v == 0 {
+			zeros++
+		}
+	}
+	if zeros == 0 {
+		zeros = 1
+	}
+
+	n := float64(len(s.sk))
+	return uint64(-float64(s.w)*math.Log(float64(zeros)/n) + 0.5)
+}
+
 // Merge the given sketch into this one.
-// The sketches must have the same dimensions.
+// The sketches must have the same depth, and the wider sketch's width
+// must be a multiple of the narrower's.  If the widths differ, the
+// wider sketch is folded down first (from is copied, not modified).
 func (s *Sketch) Merge(from *Sketch) {
-	if len(s.sk) != len(from.sk) || len(s.sk[0]) != len(from.sk[0]) {
+	w, fw := s.w, from.w
+	if s.d != from.d || (w%fw != 0 && fw%w != 0) {
 		panic("Can't merge different sketches with different dimensions")
 	}
 
-	for i, l := range from.sk {
-		for j, v := range l {
-			s.sk[i][j] += v
-		}
+	if w > fw {
+		s.Fold(w / fw)
+	} else if fw > w {
+		from = from.Clone()
+		from.Fold(fw / w)
+	}
+
+	for i, v := range from.sk {
+		s.sk[i] += v
+	}
+
+	for i, v := range from.rowCounts {
+		s.rowCounts[i] += v
 	}
 }
 
 // Clone returns a copy of this sketch
 func (s *Sketch) Clone() *Sketch {
 
-	w := len(s.sk[0])
-	d := len(s.sk)
+	w := s.w
+	d := s.d
 
 	clone := NewSketch(w, d)
 
-	for i, l := range s.sk {
-		copy(clone.sk[i], l)
-	}
+	copy(clone.sk, s.sk)
 
 	copy(clone.rowCounts, s.rowCounts)
+	clone.est = s.est
+	clone.resetAfter = s.resetAfter
+	clone.additions = s.additions
 
 	return clone
 }
@@ -302,21 +535,77 @@ http://www.auai.org/uai2012/papers/231.pdf
 // the accuracy.  This routine panics if the width is not a power of
 // two.
 func (s *Sketch) Compress() {
-	w := len(s.sk[0])
+	w := s.w
 
 	if w&(w-1) != 0 {
 		panic("width must be a power of two")
 	}
 
-	neww := w / 2
+	s.Fold(2)
+}
+
+// Fold reduces the width of the sketch by the given factor, which
+// must divide the width.  Counters that are w/factor apart are summed
+// together, so folding by a then b is the same as folding by a*b.
+func (s *Sketch) Fold(factor int) {
+	w := s.w
+	d := s.d
+
+	if factor < 1 || w%factor != 0 {
+		panic("fold factor must divide the width")
+	}
+	if s.fixed && factor > 1 {
+		panic("can't fold a sketch with a fixed width")
+	}
+
+	neww := w / factor
+
+	// Rows are compacted towards the front in place.  Row i's new
+	// counters never land past any of its old counters that are
+	// still to be read, nor on any later row.
+	for i := 0; i < d; i++ {
+		l := s.sk[i*w : (i+1)*w]
+		row := s.sk[i*neww : (i+1)*neww]
+		for j := range row {
+			v := l[j]
+			for k := 1; k < factor; k++ {
+				v += l[j+k*neww]
+			}
+			row[j] = v
+		}
+	}
+
+	// Reslice, but reallocate once most of the original array is
+	// unused so the old space can actually be garbage collected.
+	if cap(s.sk) >= 4*neww*d {
+		sk := make([]uint32, neww*d)
+		copy(sk, s.sk)
+		s.sk = sk
+	} else {
+		s.sk = s.sk[:neww*d]
+	}
+	s.w = neww
+}
+
+// Bytes returns the memory used by the sketch's counters.
+func (s *Sketch) Bytes() int {
+	return 4 * len(s.sk)
+}
 
-	for i, l := range s.sk {
-		// We allocate a new array here so old space can actually be garbage collected.
-		// TODO(dgryski): reslice and only reallocate every few compressions
-		row := make([]uint32, neww)
-		for j := 0; j < neww; j++ {
-			row[j] = l[j] + l[j+neww]
+// CompressTo folds the sketch by the smallest factor that brings its
+// counters under maxBytes, and reports whether it now fits.  If no
+// divisor of the width is small enough, the sketch is left alone.
+func (s *Sketch) CompressTo(maxBytes int) bool {
+	w := s.w
+	d := s.d
+
+	for factor := 1; factor <= w; factor++ {
+		if w%factor == 0 && 4*d*(w/factor) <= maxBytes {
+			if factor > 1 {
+				s.Fold(factor)
+			}
+			return true
 		}
-		s.sk[i] = row
 	}
+	return false
 }
@@ -1,6 +1,13 @@
 package probably
 
 import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -159,6 +166,151 @@ func TestConservativeCounting(t *testing.T) {
 	}
 }
 
+func TestEstimators(t *testing.T) {
+	// A skewed stream: a few keys are very frequent, most are rare.
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.2, 1, 9999)
+	stream := make([]string, 20000)
+	for i := range stream {
+		stream[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+
+	for d := 1; d <= 8; d++ {
+		s := NewSketch(512, d)
+		exact := map[string]uint32{}
+		for _, k := range stream {
+			s.Increment(k)
+			exact[k]++
+		}
+
+		var errs [3]float64
+		for k, v := range exact {
+			for _, e := range []Estimator{Min, MeanMin, Median} {
+				s.SetEstimator(e)
+				got := s.Count(k)
+				errs[e] += abs(float64(got) - float64(v))
+
+				if e == Min && got < v {
+					t.Errorf("d=%v: Min underestimated %v: %v < %v", d, k, got, v)
+				}
+			}
+
+			s.SetEstimator(Min)
+			min := s.Count(k)
+			if mm := s.CountMeanMin(k); mm > min {
+				t.Errorf("d=%v: MeanMin over min for %v: %v > %v", d, k, mm, min)
+			}
+		}
+
+		n := float64(len(exact))
+		t.Logf("d=%v mean abs error: min=%.2f meanmin=%.2f median=%.2f",
+			d, errs[Min]/n, errs[MeanMin]/n, errs[Median]/n)
+
+		// Once there are enough rows for Min to dodge most
+		// collisions, the two are about even.
+		if d <= 4 && errs[MeanMin] > errs[Min] {
+			t.Errorf("d=%v: expected MeanMin to beat Min on a skewed stream", d)
+		}
+	}
+}
+
+func TestEstimatorAllocs(t *testing.T) {
+	s := NewSketch(64, 8)
+	s.Increment("hello")
+
+	for _, e := range []Estimator{Min, MeanMin, Median} {
+		s.SetEstimator(e)
+		allocs := testing.AllocsPerRun(100, func() {
+			s.Count("hello")
+		})
+		if allocs != 0 {
+			t.Errorf("Expected no allocations for estimator %v, got %v", e, allocs)
+		}
+	}
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestHalve(t *testing.T) {
+	s := NewSketch(64, 3)
+
+	s.Add("hello", 5)
+	s.Add("there", 2)
+
+	s.Halve()
+
+	if s.Count("hello") != 2 || s.Count("there") != 1 {
+		t.Errorf("Expected 2 and 1 after halving, got %v and %v",
+			s.Count("hello"), s.Count("there"))
+	}
+	for i, v := range s.rowCounts {
+		if v != 3 {
+			t.Errorf("Expected row %v total 3, got %v", i, v)
+		}
+	}
+
+	s.Scale(0.5)
+	if s.Count("hello") != 1 || s.Count("there") != 0 {
+		t.Errorf("Expected 1 and 0 after scaling, got %v and %v",
+			s.Count("hello"), s.Count("there"))
+	}
+}
+
+func TestResetAfter(t *testing.T) {
+	s := NewSketch(64, 3)
+	s.SetResetAfter(10)
+
+	for i := 0; i < 9; i++ {
+		s.ConservativeIncrement("hello")
+	}
+	if got := s.ConservativeIncrement("hello"); got != 5 {
+		t.Errorf("Expected 5 right after the reset, got %v", got)
+	}
+	if got := s.Count("hello"); got != 5 {
+		t.Errorf("Expected 5 after the reset, got %v", got)
+	}
+
+	// The next reset comes after another half-sample.
+	for i := 0; i < 4; i++ {
+		s.Increment("hello")
+	}
+	if got := s.Increment("hello"); got != 5 {
+		t.Errorf("Expected 5 after the second reset, got %v", got)
+	}
+}
+
+func TestDistinctEstimate(t *testing.T) {
+	s := NewSketch(100000, 4)
+
+	if got := s.DistinctEstimate(); got != 0 {
+		t.Errorf("Expected 0 for an empty sketch, got %v", got)
+	}
+
+	for i := 0; i < 50000; i++ {
+		k := strconv.Itoa(i)
+		s.Increment(k)
+		s.Increment(k)
+	}
+
+	got := s.DistinctEstimate()
+	if got < 49000 || got > 51000 {
+		t.Errorf("Expected about 50000 distinct, got %v", got)
+	}
+
+	s = NewSketch(4, 2)
+	for i := 0; i < 1000; i++ {
+		s.Increment(strconv.Itoa(i))
+	}
+	if got, exp := s.DistinctEstimate(), uint64(8); got != exp {
+		t.Errorf("Expected saturated estimate %v, got %v", exp, got)
+	}
+}
+
 func TestMerging(t *testing.T) {
 	s := NewSketch(8, 3)
 
@@ -203,13 +355,14 @@ func TestCompress(t *testing.T) {
 	s.Increment(hello)
 	s.Increment(there)
 
-	for _, l := range s.sk {
-		t.Log(l)
+	for i := 0; i < s.d; i++ {
+		t.Log(s.row(i))
 	}
 
 	s.Compress()
 
-	for _, l := range s.sk {
+	for i := 0; i < s.d; i++ {
+		l := s.row(i)
 		t.Log(l)
 		if len(l) != 4 {
 			t.Errorf("Expected length 4, got %v\n", len(l))
@@ -232,6 +385,132 @@ func TestCompress(t *testing.T) {
 	}
 }
 
+func TestFold(t *testing.T) {
+	s := NewSketch(12, 3)
+
+	s.Add("hello", 2)
+	s.Increment("there")
+
+	s.Fold(3)
+
+	if s.w != 4 || len(s.sk) != 12 {
+		t.Errorf("Expected width 4, got %v (%v counters)", s.w, len(s.sk))
+	}
+
+	if s.Count("hello") != 2 || s.Count("there") != 1 {
+		t.Errorf("Expected 2 and 1 after folding, got %v and %v",
+			s.Count("hello"), s.Count("there"))
+	}
+
+	// Folding twice leaves a quarter of the capacity in use
+	// and forces a reallocation.
+	s = NewSketch(64, 3)
+	s.Fold(2)
+	s.Fold(2)
+	if cap(s.sk) != 48 {
+		t.Errorf("Expected counters to be reallocated, capacity is %v", cap(s.sk))
+	}
+}
+
+func TestCompressTo(t *testing.T) {
+	s := NewSketch(30, 2)
+	s.Increment("hello")
+
+	if !s.CompressTo(4 * 2 * 12) {
+		t.Fatalf("Expected sketch to fit")
+	}
+	if w := s.w; w != 10 {
+		t.Errorf("Expected width 10, got %v", w)
+	}
+	if s.Bytes() != 80 {
+		t.Errorf("Expected 80 bytes, got %v", s.Bytes())
+	}
+	if s.Count("hello") != 1 {
+		t.Errorf("Expected 1 for hello, got %v", s.Count("hello"))
+	}
+
+	if s.CompressTo(4) {
+		t.Errorf("Expected sketch not to fit in 4 bytes")
+	}
+	if w := s.w; w != 10 {
+		t.Errorf("Expected width to be unchanged, got %v", w)
+	}
+}
+
+func TestMergeFolded(t *testing.T) {
+	wide := NewSketch(16, 3)
+	narrow := NewSketch(4, 3)
+
+	wide.Increment("hello")
+	narrow.Increment("hello")
+	narrow.Increment("there")
+
+	s := narrow.Clone()
+	s.Merge(wide)
+	if wide.w != 16 {
+		t.Errorf("Merge modified its argument")
+	}
+	if s.Count("hello") != 2 || s.Count("there") != 1 {
+		t.Errorf("Expected 2 and 1, got %v and %v",
+			s.Count("hello"), s.Count("there"))
+	}
+
+	wide.Merge(narrow)
+	if wide.w != 4 {
+		t.Errorf("Expected width 4, got %v", wide.w)
+	}
+	if wide.Count("hello") != 2 || wide.Count("there") != 1 {
+		t.Errorf("Expected 2 and 1, got %v and %v",
+			wide.Count("hello"), wide.Count("there"))
+	}
+}
+
+func TestHashN(t *testing.T) {
+	for _, w := range words {
+		fnv1a := fnv.New32a()
+		fnv1a.Write([]byte(w))
+		if h1, _ := hashn(w); h1 != fnv1a.Sum32() {
+			t.Errorf("Expected fnv-1a %v for %v, got %v", fnv1a.Sum32(), w, h1)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s := NewSketch(64, 4)
+	s2 := NewSketch(64, 4)
+
+	keys := []string{"hello", "there", "hello"}
+	s.AddBatch(keys, 2)
+
+	k := NewKey("hello")
+	s2.AddKey(k, 3)
+	s2.ConservativeAddKey(k, 1)
+
+	got := s.CountBatch([]string{"hello", "there", "world"}, nil)
+	exp := []uint32{4, 2, 0}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("Expected %v at %v, got %v", exp[i], i, got[i])
+		}
+	}
+
+	if s.CountKey(k) != s.Count("hello") || s2.CountKey(k) != 4 {
+		t.Errorf("Expected 4 for key, got %v and %v", s.CountKey(k), s2.CountKey(k))
+	}
+
+	dst := make([]uint32, 0, len(keys))
+	allocs := testing.AllocsPerRun(100, func() {
+		s.AddBatch(keys, 1)
+		dst = s.CountBatch(keys, dst[:0])
+		s.Add("hello", 1)
+		s.ConservativeAdd("hello", 1)
+		s.Count("hello")
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %v", allocs)
+	}
+}
+
 func BenchmarkHashNStringDepth64(b *testing.B) {
 	s := "this is a test string to hash"
 
@@ -245,3 +524,106 @@ func BenchmarkHashNStringDepth64(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkCountBatch(b *testing.B) {
+	s := NewSketch(1<<16, 5)
+	s.AddBatch(benchKeys, 1)
+	dst := make([]uint32, 0, len(benchKeys))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dst = s.CountBatch(benchKeys, dst[:0])
+	}
+}
+
+func BenchmarkSketchAdd(b *testing.B) {
+	s := NewSketch(1<<20, 5)
+
+	for i := 0; i < b.N; i++ {
+		s.Increment(benchKeys[i%len(benchKeys)])
+	}
+}
+
+func BenchmarkSketchCount(b *testing.B) {
+	s := NewSketch(1<<20, 5)
+	s.AddBatch(benchKeys, 1)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Count(benchKeys[i%len(benchKeys)])
+	}
+}
+
+func BenchmarkSketchMerge(b *testing.B) {
+	s := NewSketch(1<<20, 5)
+	from := NewSketch(1<<20, 5)
+	from.AddBatch(benchKeys, 1)
+
+	b.SetBytes(int64(from.Bytes()))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Merge(from)
+	}
+}
+
+func TestSketchMarshal(t *testing.T) {
+	s := NewSketch(64, 4)
+	s.SetEstimator(MeanMin)
+	s.SetResetAfter(100)
+	s.Add("hello", 3)
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+
+	var got Sketch
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error unmarshaling: %v", err)
+	}
+	if !reflect.DeepEqual(s, &got) {
+		t.Errorf("Expected %+v, got %+v", s, got)
+	}
+}
+
+func TestSketchUnmarshalOversized(t *testing.T) {
+	header := func(w, d uint32) []byte {
+		buf := &bytes.Buffer{}
+		buf.WriteByte(sketchEncodingVersion)
+		binary.Write(buf, binary.LittleEndian, []uint32{w, d})
+		buf.WriteByte(byte(Min))
+		binary.Write(buf, binary.LittleEndian, []uint64{0, 0})
+		return buf.Bytes()
+	}
+
+	tests := []struct{ w, d uint32 }{
+		{1<<31 - 1, 1 << 31},
+		{1 << 31, 1<<31 - 1},
+		{math.MaxUint32, math.MaxUint32},
+		{1, math.MaxUint32},
+		{math.MaxUint32, 1},
+		{1 << 16, 1 << 16},
+	}
+	for _, test := range tests {
+		var s Sketch
+		if err := s.UnmarshalBinary(header(test.w, test.d)); err != errBadEncoding {
+			t.Errorf("Expected %v for %vx%v, got %v", errBadEncoding, test.w, test.d, err)
+		}
+	}
+
+	// Random headers with a little data after them never allocate more
+	// than the data could hold.
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		data := header(rnd.Uint32()>>uint(rnd.Intn(32)), rnd.Uint32()>>uint(rnd.Intn(32)))
+		data = append(data, make([]byte, rnd.Intn(64))...)
+		var s Sketch
+		if s.UnmarshalBinary(data) == nil && 4*(s.d+s.w*s.d) > len(data) {
+			t.Fatalf("Decoded %vx%v from %v bytes", s.w, s.d, len(data))
+		}
+	}
+}
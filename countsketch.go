@@ -0,0 +1,117 @@
+package probably
+
+import (
+	"fmt"
+	"math"
+)
+
+// CountSketch is a Count Sketch: like a count-min sketch, but each
+// input is added to or subtracted from its counters depending on its
+// hash, so collisions tend to cancel out rather than pile up.  The
+// estimate is the median, which is unbiased, but can be too low as
+// well as too high.
+//
+// See "Finding Frequent Items in Data Streams" (Charikar, Chen,
+// Farach-Colton, 2002).
+type CountSketch struct {
+	w, d int
+	sk   []int64 // d rows of w counters, one after another
+}
+
+// NewCountSketch returns a new Count Sketch with the given width and
+// depth.
+func NewCountSketch(w, d int) *CountSketch {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+
+	return &CountSketch{
+		w:  w,
+		d:  d,
+		sk: make([]int64, w*d),
+	}
+}
+
+func (s CountSketch) String() string {
+	return fmt.Sprintf("{CountSketch %dx%d}", s.w, s.d)
+}
+
+// index returns the position of the input in row i, and whether it's
+// added or subtracted there.
+func (s *CountSketch) index(i int, h1, h2 uint32) (int, int64) {
+	h := h1 + uint32(i)*h2
+	sign := int64(1)
+	if h&(1<<31) != 0 {
+		sign = -1
+	}
+	return i*s.w + int(h%uint32(s.w)), sign
+}
+
+// Add 'count' occurences of the given input, and return its estimated
+// count.
+func (s *CountSketch) Add(h string, count uint32) uint32 {
+	h1, h2 := hashn(h)
+	for i := 0; i < s.d; i++ {
+		pos, sign := s.index(i, h1, h2)
+		s.sk[pos] += sign * int64(count)
+	}
+	return s.count(h1, h2)
+}
+
+// Count returns the estimated count for the given input.
+func (s *CountSketch) Count(h string) uint32 {
+	h1, h2 := hashn(h)
+	return s.count(h1, h2)
+}
+
+func (s *CountSketch) count(h1, h2 uint32) uint32 {
+	var buf [maxStackDepth]float64
+	vals := buf[:0]
+	if s.d > maxStackDepth {
+		vals = make([]float64, 0, s.d)
+	}
+
+	for i := 0; i < s.d; i++ {
+		pos, sign := s.index(i, h1, h2)
+		vals = append(vals, float64(sign*s.sk[pos]))
+	}
+
+	m := medianf(vals)
+	// negative count doesn't make sense
+	if m < 0 {
+		return 0
+	}
+	if m > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(m)
+}
+
+// Reset clears all the values from the sketch.
+func (s *CountSketch) Reset() {
+	for i := range s.sk {
+		s.sk[i] = 0
+	}
+}
+
+// Merge the given sketch into this one.
+func (s *CountSketch) Merge(from *CountSketch) {
+	if s.w != from.w || s.d != from.d {
+		panic("Can't merge different sketches with different dimensions")
+	}
+
+	for i, v := range from.sk {
+		s.sk[i] += v
+	}
+}
+
+// MergeFrequency merges in the counts from another CountSketch with the
+// same dimensions.
+func (s *CountSketch) MergeFrequency(from FrequencyEstimator) error {
+	f, ok := from.(*CountSketch)
+	if !ok || s.w != f.w || s.d != f.d {
+		return errCantMerge
+	}
+	s.Merge(f)
+	return nil
+}
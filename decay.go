@@ -0,0 +1,114 @@
+package probably
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Once the weight of new updates grows past e^maxDecayExp, all
+// counters are scaled back down and the landmark is moved forward.
+const maxDecayExp = 100
+
+// DecayedSketch is a count-min sketch whose counts decay exponentially
+// with age, so recent activity dominates.
+//
+// Rather than touching every counter as time passes, each update is
+// weighted by exp(λ·(t-landmark)) and queries divide that growth back
+// out.  When the weights get large, the counters are renormalised
+// against a new landmark.
+//
+// See "Forward Decay: A Practical Time Decay Model for Streaming
+// Systems" (Cormode, Shkapenyuk, Srivastava, Xu, 2009).
+type DecayedSketch struct {
+	w, d     int
+	sk       []float64
+	lambda   float64
+	landmark time.Time
+}
+
+// NewDecayedSketch returns a new decayed count-min sketch with the
+// given width and depth.  Counts decay by a factor of e every 1/λ
+// seconds; use λ = ln 2 / halflife.Seconds() to think in half-lives.
+func NewDecayedSketch(w, d int, lambda float64) *DecayedSketch {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+	if lambda < 0 {
+		panic("Decay rate must not be negative")
+	}
+
+	return &DecayedSketch{
+		w:      w,
+		d:      d,
+		sk:     make([]float64, w*d),
+		lambda: lambda,
+	}
+}
+
+func (s DecayedSketch) String() string {
+	return fmt.Sprintf("{DecayedSketch %dx%d λ=%v}", s.w, s.d, s.lambda)
+}
+
+// exponent returns λ·(t-landmark), initializing the landmark on
+// first use.
+func (s *DecayedSketch) exponent(t time.Time) float64 {
+	if s.landmark.IsZero() {
+		s.landmark = t
+	}
+	return s.lambda * t.Sub(s.landmark).Seconds()
+}
+
+// renormalise scales every counter so that t becomes the landmark.
+func (s *DecayedSketch) renormalise(t time.Time) {
+	scale := math.Exp(-s.exponent(t))
+	for i := range s.sk {
+		s.sk[i] *= scale
+	}
+	s.landmark = t
+}
+
+// AddAt adds 'count' occurences of the given input at time t and
+// returns its decayed count as of t.
+func (s *DecayedSketch) AddAt(h string, count uint32, t time.Time) float64 {
+	e := s.exponent(t)
+	if e > maxDecayExp {
+		s.renormalise(t)
+		e = 0
+	}
+
+	w := s.w
+	d := s.d
+	weight := float64(count) * math.Exp(e)
+	val := math.Inf(1)
+	h1, h2 := hashn(h)
+	for i := 0; i < d; i++ {
+		pos := i*w + int((h1+uint32(i)*h2)%uint32(w))
+		v := s.sk[pos] + weight
+		s.sk[pos] = v
+		if v < val {
+			val = v
+		}
+	}
+	return val * math.Exp(-e)
+}
+
+// CountAt returns the estimated decayed count for the given input as
+// of time t.
+func (s *DecayedSketch) CountAt(h string, t time.Time) float64 {
+	if s.landmark.IsZero() {
+		return 0
+	}
+
+	w := s.w
+	d := s.d
+	min := math.Inf(1)
+	h1, h2 := hashn(h)
+	for i := 0; i < d; i++ {
+		pos := i*w + int((h1+uint32(i)*h2)%uint32(w))
+		if v := s.sk[pos]; v < min {
+			min = v
+		}
+	}
+	return min * math.Exp(-s.exponent(t))
+}
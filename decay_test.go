@@ -0,0 +1,57 @@
+package probably
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayedCounting(t *testing.T) {
+	halflife := time.Minute
+	s := NewDecayedSketch(64, 4, math.Ln2/halflife.Seconds())
+
+	t0 := time.Unix(1000000, 0)
+
+	if v := s.AddAt("hello", 8, t0); math.Abs(v-8) > 1e-9 {
+		t.Fatalf("Expected 8 right after adding, got %v", v)
+	}
+	s.AddAt("there", 1, t0)
+
+	exp := []struct {
+		s string
+		t time.Time
+		v float64
+	}{
+		{"hello", t0, 8},
+		{"hello", t0.Add(halflife), 4},
+		{"hello", t0.Add(3 * halflife), 1},
+		{"there", t0.Add(halflife), 0.5},
+		{"world", t0, 0},
+	}
+
+	for _, e := range exp {
+		if got := s.CountAt(e.s, e.t); math.Abs(got-e.v) > 1e-9 {
+			t.Errorf("Expected %v for %v at %v, got %v", e.v, e.s, e.t, got)
+		}
+	}
+}
+
+func TestDecayedRenormalise(t *testing.T) {
+	s := NewDecayedSketch(64, 4, 1)
+
+	t0 := time.Unix(1000000, 0)
+	for i := 0; i < 1000; i++ {
+		s.AddAt("hello", 1, t0.Add(time.Duration(i)*time.Second))
+	}
+
+	tn := t0.Add(999 * time.Second)
+	if !s.landmark.After(t0) {
+		t.Errorf("Expected landmark to move forward, still at %v", s.landmark)
+	}
+
+	// Geometric series: 1 + e^-1 + e^-2 + ...
+	exp := 1 / (1 - math.Exp(-1))
+	if got := s.CountAt("hello", tn); math.Abs(got-exp) > 1e-9 {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,171 @@
+package probably
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// DistinctTop tracks the keys with the most distinct elements, such as
+// the sources contacting the most distinct destinations.
+//
+// Elements are counted in a count-min shaped grid whose cells are small
+// HyperLogLogs: each key adds its elements to one cell per row, and its
+// estimate is the smallest of those cells, since sharing a cell with
+// other keys can only inflate it.  Candidates are tracked in a min-heap
+// like StreamTop's.
+//
+// See "Streaming Algorithms for Fast Detection of Superspreaders"
+// (Venkataraman, Song, Gibbons, Blum, 2005) and "Finding Top-k
+// Elements in Data Streams" (Cormode, Muthukrishnan, 2005).
+type DistinctTop struct {
+	w, d   int
+	m      int // registers per cell
+	kComp  int
+	alphaM float64
+	regs   []uint8
+
+	maxItems int
+	keys     map[string]*topItem
+	heap     topHeap
+}
+
+// NewDistinctTop returns an estimator for the 'maxItems' keys with the
+// most distinct elements.  It uses a w by d grid of HyperLogLogs, each
+// created to count to within the given stderr.
+func NewDistinctTop(w, d int, stdErr float64, maxItems int) *DistinctTop {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+
+	proto := NewHyperLogLog(stdErr)
+	return &DistinctTop{
+		w:        w,
+		d:        d,
+		m:        int(proto.m),
+		kComp:    proto.kComp,
+		alphaM:   hllAlpha(proto.m),
+		regs:     make([]uint8, w*d*int(proto.m)),
+		maxItems: maxItems,
+		keys:     make(map[string]*topItem),
+	}
+}
+
+func (s *DistinctTop) String() string {
+	return fmt.Sprintf("{DistinctTop %dx%d of %d registers}", s.w, s.d, s.m)
+}
+
+// cell returns the registers in row i for the given hash.
+func (s *DistinctTop) cell(i int, h1, h2 uint32) []uint8 {
+	j := i*s.w + int((h1+uint32(i)*h2)%uint32(s.w))
+	return s.regs[j*s.m : (j+1)*s.m]
+}
+
+// Distinct returns the estimated number of distinct elements added for
+// the given key.
+func (s *DistinctTop) Distinct(key string) uint64 {
+	h1, h2 := hashn(key)
+	var rv uint64
+	for i := 0; i < s.d; i++ {
+		c := hllCount(s.cell(i, h1, h2), s.alphaM)
+		if i == 0 || c < rv {
+			rv = c
+		}
+	}
+	return rv
+}
+
+func (s *DistinctTop) distinct32(key string) uint32 {
+	c := s.Distinct(key)
+	if c > 1<<32-1 {
+		return 1<<32 - 1
+	}
+	return uint32(c)
+}
+
+// Add an element, by its hash, for the given key.
+//
+// Elements already seen for a key rarely change any register, and cost
+// only d register updates.
+func (s *DistinctTop) Add(key string, elementHash uint32) {
+	h1, h2 := hashn(key)
+	changed := false
+	for i := 0; i < s.d; i++ {
+		if hllAdd(s.cell(i, h1, h2), s.kComp, elementHash) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	count := s.distinct32(key)
+	if it, ok := s.keys[key]; ok {
+		it.count = count
+		heap.Fix(&s.heap, it.index)
+		return
+	}
+
+	if len(s.heap) < s.maxItems {
+		it := &topItem{key: key, count: count}
+		s.keys[key] = it
+		heap.Push(&s.heap, it)
+		return
+	}
+
+	if len(s.heap) == 0 || count <= s.heap[0].count {
+		return
+	}
+	min := s.heap[0]
+	delete(s.keys, min.key)
+
+	it := &topItem{key: key, count: count}
+	s.heap[0] = it
+	s.keys[key] = it
+	heap.Fix(&s.heap, 0)
+}
+
+// GetTop returns the keys with the most distinct elements.
+func (s *DistinctTop) GetTop() []ItemCount {
+	rv := make([]ItemCount, 0, len(s.heap))
+	for _, it := range s.heap.sorted() {
+		rv = append(rv, ItemCount{it.key, it.count})
+	}
+	return rv
+}
+
+// Merge the given estimator into this one.  Both must have the same
+// dimensions and register count.
+func (s *DistinctTop) Merge(from *DistinctTop) {
+	if s.w != from.w || s.d != from.d || s.m != from.m {
+		panic("Can't merge estimators of different sizes")
+	}
+
+	for i, v := range from.regs {
+		if v > s.regs[i] {
+			s.regs[i] = v
+		}
+	}
+
+	// Every candidate's estimate may have grown, so rank them afresh.
+	d := make(map[string]*topItem, len(s.keys)+len(from.keys))
+	for k := range s.keys {
+		d[k] = &topItem{key: k}
+	}
+	for k := range from.keys {
+		d[k] = &topItem{key: k}
+	}
+	h := make(topHeap, 0, len(d))
+	for k, it := range d {
+		it.count = s.distinct32(k)
+		h.Push(it)
+	}
+	heap.Init(&h)
+
+	for len(h) > s.maxItems {
+		it := heap.Pop(&h).(*topItem)
+		delete(d, it.key)
+	}
+
+	s.keys = d
+	s.heap = h
+}
@@ -0,0 +1,87 @@
+package probably
+
+import (
+	"hash/crc32"
+	"strconv"
+	"testing"
+)
+
+// elementHash hashes an element, with murmur3's finalizer to spread
+// the bits of nearly identical inputs.
+func elementHash(s string) uint32 {
+	h := crc32.ChecksumIEEE([]byte(s))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// spreadStream calls f for a few sources contacting many destinations,
+// among many sources repeatedly contacting a few.
+func spreadStream(f func(i int, src string, dst uint32)) {
+	i := 0
+	for round := 0; round < 10; round++ {
+		for s := 0; s < 1000; s++ {
+			src := "normal" + strconv.Itoa(s)
+			dst := src + "->" + strconv.Itoa(round%5)
+			f(i, src, elementHash(dst))
+			i++
+		}
+		for s := 0; s < 5; s++ {
+			src := "spreader" + strconv.Itoa(s)
+			for j := 0; j < (5-s)*10; j++ {
+				dst := src + "->" + strconv.Itoa(round*1000+j)
+				f(i, src, elementHash(dst))
+				i++
+			}
+		}
+	}
+}
+
+func TestDistinctTop(t *testing.T) {
+	s := NewDistinctTop(256, 4, 0.1, 5)
+	spreadStream(func(i int, src string, dst uint32) {
+		s.Add(src, dst)
+	})
+
+	top := s.GetTop()
+	if len(top) != 5 {
+		t.Fatalf("Expected 5 items, got %v", top)
+	}
+	if top[0].Key != "spreader0" {
+		t.Errorf("Expected spreader0 on top, got %v", top)
+	}
+	for _, ic := range top[:3] {
+		if ic.Key[:8] != "spreader" {
+			t.Errorf("Expected spreaders at the top, got %v", top)
+		}
+	}
+	if c := s.Distinct("spreader0"); c < 400 || c > 600 {
+		t.Errorf("Expected about 500 for spreader0, got %v", c)
+	}
+
+	// Splitting the stream and merging gives the same answer.
+	a, b := NewDistinctTop(256, 4, 0.1, 5), NewDistinctTop(256, 4, 0.1, 5)
+	spreadStream(func(i int, src string, dst uint32) {
+		if i%2 == 0 {
+			a.Add(src, dst)
+		} else {
+			b.Add(src, dst)
+		}
+	})
+	a.Merge(b)
+
+	if got := a.GetTop(); got[0] != top[0] {
+		t.Errorf("Expected %v on top after merging, got %v", top[0], got)
+	}
+}
+
+func TestDistinctTopNoItems(t *testing.T) {
+	s := NewDistinctTop(256, 4, 0.1, 0)
+	s.Add("a", 1)
+	if top := s.GetTop(); len(top) != 0 {
+		t.Errorf("Expected nothing, got %v", top)
+	}
+}
@@ -0,0 +1,192 @@
+package probably
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	sketchEncodingVersion    = 1
+	streamTopEncodingVersion = 1
+)
+
+var (
+	errBadEncoding = errors.New("invalid encoding")
+	errNoSketch    = errors.New("only a StreamTop backed by a Sketch can be encoded")
+)
+
+// MarshalBinary encodes the sketch, including its estimator and aging
+// settings.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 32+4*(len(s.rowCounts)+len(s.sk))))
+
+	buf.WriteByte(sketchEncodingVersion)
+	binary.Write(buf, binary.LittleEndian, uint32(s.w))
+	binary.Write(buf, binary.LittleEndian, uint32(s.d))
+	buf.WriteByte(byte(s.est))
+	binary.Write(buf, binary.LittleEndian, s.resetAfter)
+	binary.Write(buf, binary.LittleEndian, s.additions)
+	binary.Write(buf, binary.LittleEndian, s.rowCounts)
+	binary.Write(buf, binary.LittleEndian, s.sk)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the sketch with one encoded by
+// MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := s.decode(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return errBadEncoding
+	}
+	return nil
+}
+
+func (s *Sketch) decode(r *bytes.Reader) error {
+	if v, err := r.ReadByte(); err != nil || v != sketchEncodingVersion {
+		return errBadEncoding
+	}
+
+	var hdr struct {
+		W, D       uint32
+		Est        uint8
+		ResetAfter uint64
+		Additions  uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return errBadEncoding
+	}
+	// Check there's room for every counter before allocating any,
+	// without letting a huge header overflow the check.
+	words := uint64(r.Len()) / 4
+	if hdr.W < 1 || hdr.D < 1 || uint64(hdr.D) > words ||
+		uint64(hdr.W) > (words-uint64(hdr.D))/uint64(hdr.D) {
+		return errBadEncoding
+	}
+	w, d := int(hdr.W), int(hdr.D)
+
+	rowCounts := make([]uint32, d)
+	sk := make([]uint32, w*d)
+	if binary.Read(r, binary.LittleEndian, rowCounts) != nil ||
+		binary.Read(r, binary.LittleEndian, sk) != nil {
+		return errBadEncoding
+	}
+
+	*s = Sketch{
+		w:          w,
+		d:          d,
+		sk:         sk,
+		rowCounts:  rowCounts,
+		est:        Estimator(hdr.Est),
+		resetAfter: hdr.ResetAfter,
+		additions:  hdr.Additions,
+	}
+	return nil
+}
+
+// MarshalBinary encodes the stream counter, including its sketch and
+// the keys it is tracking, so that it can be merged elsewhere.  It's
+// decoded with a conservatively updated Sketch, as from NewStreamTop.
+func (s *StreamTop) MarshalBinary() ([]byte, error) {
+	if s.sketch() == nil {
+		return nil, errNoSketch
+	}
+	sk, err := s.sketch().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(streamTopEncodingVersion)
+	binary.Write(buf, binary.LittleEndian, s.thresh)
+	binary.Write(buf, binary.LittleEndian, uint32(s.maxItems))
+	binary.Write(buf, binary.LittleEndian, s.n)
+	binary.Write(buf, binary.LittleEndian, s.maxThresh)
+
+	writeUvarint(buf, uint64(len(s.keys)))
+	for k, it := range s.keys {
+		writeUvarint(buf, uint64(len(k)))
+		buf.WriteString(k)
+		binary.Write(buf, binary.LittleEndian, []uint32{it.count, it.seen, it.prior})
+	}
+
+	buf.Write(sk)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the stream counter with one encoded by
+// MarshalBinary.
+func (s *StreamTop) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if v, err := r.ReadByte(); err != nil || v != streamTopEncodingVersion {
+		return errBadEncoding
+	}
+
+	var hdr struct {
+		Thresh, MaxItems uint32
+		N                uint64
+		MaxThresh        uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return errBadEncoding
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return errBadEncoding
+	}
+	keys := make(map[string]*topItem, n)
+	h := make(topHeap, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return errBadEncoding
+		}
+		k := make([]byte, l)
+		var it [3]uint32
+		if _, err := io.ReadFull(r, k); err != nil {
+			return errBadEncoding
+		}
+		if err := binary.Read(r, binary.LittleEndian, &it); err != nil {
+			return errBadEncoding
+		}
+		ti := &topItem{key: string(k), count: it[0], seen: it[1], prior: it[2]}
+		keys[ti.key] = ti
+		h.Push(ti)
+	}
+
+	heap.Init(&h)
+
+	sk := &Sketch{}
+	if err := sk.decode(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return errBadEncoding
+	}
+
+	*s = StreamTop{
+		est: ConservativeSketch{sk},
+		topSet: topSet{
+			thresh:    hdr.Thresh,
+			maxItems:  int(hdr.MaxItems),
+			keys:      keys,
+			heap:      h,
+			n:         hdr.N,
+			maxThresh: hdr.MaxThresh,
+		},
+	}
+	return nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
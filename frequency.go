@@ -0,0 +1,181 @@
+package probably
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// FrequencyEstimator estimates how often items occur in a stream.  A
+// StreamTop uses one to decide which items are the most frequent.
+//
+// An estimator can also have any of these methods, which a StreamTop
+// uses if they're there:
+//
+//	// MergeFrequency adds the counts in from to these, or returns an
+//	// error if they can't be merged.
+//	MergeFrequency(from FrequencyEstimator) error
+//	// ErrorBound returns how much a count could be overestimated
+//	// after n occurences in all.
+//	ErrorBound(n uint64) uint32
+//	// Decays reports whether counts go down over time.
+//	Decays() bool
+//	// Reset clears all the counts.
+//	Reset()
+type FrequencyEstimator interface {
+	// Add adds 'count' occurences of the given input, and returns its
+	// estimated count.
+	Add(h string, count uint32) uint32
+	// Count returns the estimated count for the given input.
+	Count(h string) uint32
+}
+
+type frequencyMerger interface {
+	MergeFrequency(from FrequencyEstimator) error
+}
+
+type errorBounder interface {
+	ErrorBound(n uint64) uint32
+}
+
+type decayer interface {
+	Decays() bool
+}
+
+type resetter interface {
+	Reset()
+}
+
+var errCantMerge = errors.New("can't merge these estimators")
+
+// ConservativeSketch is a Sketch that adds conservatively, which is
+// what a StreamTop uses by default.
+type ConservativeSketch struct {
+	*Sketch
+}
+
+// Add 'count' occurences of the given input, conservatively.
+func (s ConservativeSketch) Add(h string, count uint32) uint32 {
+	return s.ConservativeAdd(h, count)
+}
+
+// ErrorBound returns how much collisions could have inflated a count:
+// with w=⌈ ℯ/𝜀 ⌉, no more than 𝜀 times the stream length n, with
+// probability 1-𝛿 for a sketch of depth ⌈ln (1/𝛿)⌉.
+func (s *Sketch) ErrorBound(n uint64) uint32 {
+	e := math.Ceil(math.E * float64(n) / float64(s.w))
+	if e > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(e)
+}
+
+// MergeFrequency merges in the counts from another estimator backed by
+// a Sketch, as Merge does.
+func (s *Sketch) MergeFrequency(from FrequencyEstimator) error {
+	f, ok := from.(interface{ sketch() *Sketch })
+	if !ok {
+		return errCantMerge
+	}
+	o := f.sketch()
+	if s.d != o.d || (s.w%o.w != 0 && o.w%s.w != 0) {
+		return errCantMerge
+	}
+	s.Merge(o)
+	return nil
+}
+
+func (s *Sketch) sketch() *Sketch {
+	return s
+}
+
+// ExactCounter counts every input exactly, in memory proportional to
+// the number of distinct inputs.  It's mostly useful for testing.
+type ExactCounter struct {
+	counts map[string]uint32
+}
+
+// NewExactCounter returns an empty ExactCounter.
+func NewExactCounter() *ExactCounter {
+	return &ExactCounter{make(map[string]uint32)}
+}
+
+// Add 'count' occurences of the given input.
+func (c *ExactCounter) Add(h string, count uint32) uint32 {
+	v := c.counts[h] + count
+	c.counts[h] = v
+	return v
+}
+
+// Count returns the count for the given input.
+func (c *ExactCounter) Count(h string) uint32 {
+	return c.counts[h]
+}
+
+// Reset clears all the counts.
+func (c *ExactCounter) Reset() {
+	c.counts = make(map[string]uint32)
+}
+
+// Merge the given counts into these.
+func (c *ExactCounter) Merge(from *ExactCounter) {
+	for k, v := range from.counts {
+		c.counts[k] += v
+	}
+}
+
+// MergeFrequency merges in the counts from another ExactCounter.
+func (c *ExactCounter) MergeFrequency(from FrequencyEstimator) error {
+	f, ok := from.(*ExactCounter)
+	if !ok {
+		return errCantMerge
+	}
+	c.Merge(f)
+	return nil
+}
+
+// ErrorBound is always zero, since the counts are exact.
+func (c *ExactCounter) ErrorBound(n uint64) uint32 {
+	return 0
+}
+
+// DecayedFrequency adapts a DecayedSketch to a FrequencyEstimator, so
+// recent occurences count for more.  Counts are as of Now, or
+// time.Now if it's nil.
+//
+// A StreamTop only updates an item's count when the item is added, or
+// when it's the least frequent, so the counts it reports for items that
+// have gone quiet will be stale.
+type DecayedFrequency struct {
+	*DecayedSketch
+	Now func() time.Time
+}
+
+func (s DecayedFrequency) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func roundCount(v float64) uint32 {
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v + 0.5)
+}
+
+// Decays is always true.
+func (s DecayedFrequency) Decays() bool {
+	return true
+}
+
+// Add 'count' occurences of the given input now.
+func (s DecayedFrequency) Add(h string, count uint32) uint32 {
+	return roundCount(s.AddAt(h, count, s.now()))
+}
+
+// Count returns the estimated decayed count for the given input now.
+func (s DecayedFrequency) Count(h string) uint32 {
+	return roundCount(s.CountAt(h, s.now()))
+}
@@ -0,0 +1,184 @@
+package probably
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStreamTopExact(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 9999)
+
+	a := NewStreamTopWith(NewExactCounter(), 20)
+	b := NewStreamTopWith(NewExactCounter(), 20)
+	exact := map[string]uint32{}
+	for i := 0; i < 20000; i++ {
+		k := strconv.FormatUint(zipf.Uint64(), 10)
+		if i%2 == 0 {
+			a.Add(k)
+		} else {
+			b.Add(k)
+		}
+		exact[k]++
+	}
+	a.Merge(b)
+
+	// With exact counts, the bounds are exact too.
+	for _, ib := range a.GetBounds() {
+		if v := exact[ib.Key]; ib.Count != v || ib.Lower != v || ib.Upper != v {
+			t.Errorf("%v: expected exactly %v, got %+v", ib.Key, v, ib)
+		}
+	}
+
+	if _, err := a.MarshalBinary(); err == nil {
+		t.Errorf("Expected an error encoding without a sketch")
+	}
+}
+
+func TestStreamTopCountSketch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 9999)
+
+	s := NewStreamTopWith(NewCountSketch(1024, 5), 10)
+	exact := NewStreamTopWith(NewExactCounter(), 10)
+	for i := 0; i < 20000; i++ {
+		k := strconv.FormatUint(zipf.Uint64(), 10)
+		s.Add(k)
+		exact.Add(k)
+	}
+
+	top, exp := s.GetTop(), exact.GetTop()
+	for i := 0; i < 3; i++ {
+		if top[i].Key != exp[i].Key || abs(float64(top[i].Count)-float64(exp[i].Count)) > 20 {
+			t.Errorf("Expected about %v at %v, got %v", exp[i], i, top[i])
+		}
+	}
+}
+
+func TestStreamTopDecayed(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	est := DecayedFrequency{NewDecayedSketch(1024, 4, 0.01), func() time.Time { return now }}
+	s := NewStreamTopWith(est, 1)
+
+	// Something that was popular a long time ago loses out to
+	// something less popular now.
+	s.AddN("old", 100)
+	now = now.Add(time.Hour)
+	s.AddN("new", 10)
+
+	if exp := []ItemCount{{"new", 10}}; !reflect.DeepEqual(s.GetTop(), exp) {
+		t.Errorf("Expected %v, got %v", exp, s.GetTop())
+	}
+}
+
+func TestStreamTopDecayedPointer(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	est := &DecayedFrequency{NewDecayedSketch(1024, 4, 0.01), func() time.Time { return now }}
+	s := NewStreamTopWith(est, 1)
+
+	s.AddN("old", 100)
+	now = now.Add(time.Hour)
+	s.AddN("new", 10)
+
+	if exp := []ItemCount{{"new", 10}}; !reflect.DeepEqual(s.GetTop(), exp) {
+		t.Errorf("Expected %v, got %v", exp, s.GetTop())
+	}
+}
+
+// mapCounter is an estimator with none of the optional methods.
+type mapCounter struct {
+	counts map[string]uint32
+}
+
+func (c *mapCounter) Add(h string, count uint32) uint32 {
+	c.counts[h] += count
+	return c.counts[h]
+}
+
+func (c *mapCounter) Count(h string) uint32 {
+	return c.counts[h]
+}
+
+// fadingCounter is a mapCounter whose counts decay, all at once, and
+// which counts how often it's asked for a count.
+type fadingCounter struct {
+	mapCounter
+	counted int
+	faded   bool // whether every count has gone down to zero
+}
+
+func (c *fadingCounter) Count(h string) uint32 {
+	c.counted++
+	if c.faded {
+		return 0
+	}
+	return c.mapCounter.Count(h)
+}
+
+func (c *fadingCounter) Decays() bool {
+	return true
+}
+
+func TestStreamTopCustomEstimator(t *testing.T) {
+	a := NewStreamTopWith(&mapCounter{counts: map[string]uint32{}}, 2)
+	b := NewStreamTopWith(&mapCounter{counts: map[string]uint32{}}, 2)
+	a.AddN("x", 3)
+	b.AddN("x", 2)
+	b.AddN("y", 4)
+	a.Merge(b)
+
+	exp := []ItemCount{{"x", 5}, {"y", 4}}
+	if !reflect.DeepEqual(a.GetTop(), exp) {
+		t.Errorf("Expected %v, got %v", exp, a.GetTop())
+	}
+	if n := a.noise(); n != 9 {
+		t.Errorf("Expected the whole stream as noise, got %v", n)
+	}
+	if a.reset() {
+		t.Errorf("Expected reset to fail without a Reset method")
+	}
+}
+
+func TestStreamTopMergeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic merging different estimators")
+		}
+	}()
+	NewStreamTop(1024, 4, 2).Merge(NewStreamTopWith(NewExactCounter(), 2))
+}
+
+func TestStreamTopRefreshMin(t *testing.T) {
+	est := &fadingCounter{mapCounter: mapCounter{counts: map[string]uint32{}}}
+	s := NewStreamTopWith(est, 1000)
+	for i := 0; i < 1000; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+
+	// Even when every tracked count has gone down, only the least
+	// frequent item is refreshed.
+	est.faded, est.counted = true, 0
+	s.Add("new")
+	if est.counted != 1 {
+		t.Errorf("Expected 1 count, got %v", est.counted)
+	}
+}
+
+func TestCountSketch(t *testing.T) {
+	s := NewCountSketch(64, 5)
+	s.Add("a", 100)
+	s.Add("b", 3)
+	if c := s.Count("a"); c < 97 || c > 103 {
+		t.Errorf("Expected about 100 for a, got %v", c)
+	}
+
+	o := NewCountSketch(64, 5)
+	o.Add("a", 50)
+	s.Merge(o)
+	if c := s.Count("a"); c < 147 || c > 153 {
+		t.Errorf("Expected about 150 for a after merging, got %v", c)
+	}
+}
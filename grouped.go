@@ -0,0 +1,107 @@
+package probably
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+)
+
+// GroupedTop tracks the top items within each of many groups, such as
+// the top URLs per country.
+//
+// All the groups share one count-min sketch, keyed by group and item,
+// and each has its own candidate set.  The candidate sets share a
+// budget of tracked items: when it runs out, the groups that have gone
+// longest without an Add are dropped.
+type GroupedTop struct {
+	sk         *Sketch
+	maxItems   int
+	maxTracked int
+	tracked    int
+
+	groups map[string]*list.Element
+	lru    *list.List // of *topGroup, most recently active first
+}
+
+type topGroup struct {
+	name string
+	topSet
+}
+
+// NewGroupedTop returns an estimator for the 'maxItems' in each group,
+// tracking no more than 'maxTracked' items across all groups.  It uses
+// a count-min sketch, which is created with width w and depth d.
+func NewGroupedTop(w, d, maxItems, maxTracked int) *GroupedTop {
+	if maxItems < 1 || maxTracked < maxItems {
+		panic("Budget must hold at least one group")
+	}
+
+	return &GroupedTop{
+		sk:         NewSketch(w, d),
+		maxItems:   maxItems,
+		maxTracked: maxTracked,
+		groups:     make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (s *GroupedTop) String() string {
+	return fmt.Sprintf("{GroupedTop %d groups, %d/%d tracked}",
+		len(s.groups), s.tracked, s.maxTracked)
+}
+
+// groupKey returns the sketch's key for an item in a group.  The
+// group's length comes first, so no two pairs share a key.
+func groupKey(group, v string) string {
+	return strconv.Itoa(len(group)) + ":" + group + v
+}
+
+// Add an item to the given group.
+func (s *GroupedTop) Add(group, v string) {
+	s.AddN(group, v, 1)
+}
+
+// AddN adds count occurences of an item to the given group.
+func (s *GroupedTop) AddN(group, v string, count uint32) {
+	est := s.sk.ConservativeAdd(groupKey(group, v), count)
+
+	var g *topGroup
+	if e, ok := s.groups[group]; ok {
+		s.lru.MoveToFront(e)
+		g = e.Value.(*topGroup)
+	} else {
+		g = &topGroup{name: group, topSet: newTopSet(s.maxItems)}
+		s.groups[group] = s.lru.PushFront(g)
+	}
+
+	before := len(g.heap)
+	g.offer(v, est, count)
+	s.tracked += len(g.heap) - before
+
+	for s.tracked > s.maxTracked {
+		e := s.lru.Back()
+		old := e.Value.(*topGroup)
+		s.lru.Remove(e)
+		delete(s.groups, old.name)
+		s.tracked -= len(old.heap)
+	}
+}
+
+// GetTop returns the top items in the given group, or nil if the group
+// isn't being tracked.
+func (s *GroupedTop) GetTop(group string) []ItemCount {
+	e, ok := s.groups[group]
+	if !ok {
+		return nil
+	}
+	return e.Value.(*topGroup).top()
+}
+
+// Groups returns the groups being tracked, most recently active first.
+func (s *GroupedTop) Groups() []string {
+	rv := make([]string, 0, len(s.groups))
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		rv = append(rv, e.Value.(*topGroup).name)
+	}
+	return rv
+}
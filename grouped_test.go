@@ -0,0 +1,60 @@
+package probably
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGroupedTop(t *testing.T) {
+	s := NewGroupedTop(1024, 4, 3, 9)
+
+	// Each group has the same items, ranked differently.
+	groups := []string{"us", "fr", "de"}
+	for g, group := range groups {
+		for i := 0; i < 10; i++ {
+			for j := 0; j < (i+g*3)%10+1; j++ {
+				s.Add(group, strconv.Itoa(i))
+			}
+		}
+	}
+
+	for g, group := range groups {
+		top := s.GetTop(group)
+		if len(top) != 3 {
+			t.Fatalf("Expected 3 items in %v, got %v", group, top)
+		}
+		for i, ic := range top {
+			exp := ItemCount{strconv.Itoa((19 - g*3 - i) % 10), uint32(10 - i)}
+			if ic != exp {
+				t.Errorf("Expected %v at %v in %v, got %v", exp, i, group, ic)
+			}
+		}
+	}
+
+	// A fourth group pushes out the least recently active one.
+	s.Add("fr", "0")
+	s.AddN("jp", "0", 5)
+	if exp := []string{"jp", "fr", "de"}; !reflect.DeepEqual(s.Groups(), exp) {
+		t.Errorf("Expected groups %v, got %v", exp, s.Groups())
+	}
+	if top := s.GetTop("us"); top != nil {
+		t.Errorf("Expected us to be dropped, got %v", top)
+	}
+	if top := s.GetTop("jp"); !reflect.DeepEqual(top, []ItemCount{{"0", 5}}) {
+		t.Errorf("Expected 0 in jp, got %v", top)
+	}
+	if s.tracked != 7 {
+		t.Errorf("Expected 7 items tracked, got %v", s.tracked)
+	}
+}
+
+func TestGroupedTopKeys(t *testing.T) {
+	s := NewGroupedTop(1024, 4, 2, 10)
+	s.AddN("a\x00b", "c", 5)
+	s.Add("a", "b\x00c")
+
+	if exp := []ItemCount{{"b\x00c", 1}}; !reflect.DeepEqual(s.GetTop("a"), exp) {
+		t.Errorf("Expected %v, got %v", exp, s.GetTop("a"))
+	}
+}
@@ -0,0 +1,109 @@
+package probably
+
+import (
+	"fmt"
+)
+
+/*
+This is Algorithm 2 "Time Aggregation" from
+
+Hokusai: Sketching Streams in Real Time (Sergiy Matusevych, Alex
+Smola, Amr Ahmed, 2012)
+
+Proceedings of the 28th International Conference on Conference on
+Uncertainty in Artificial Intelligence (UAI)
+
+http://www.auai.org/uai2012/papers/231.pdf
+
+Level j holds the sum of the most recent complete, aligned block of
+2^j intervals, compressed (item aggregation) j times.  This needs
+only logarithmically many sketches, and the resolution of a query
+degrades gracefully with the age of the interval asked about.
+*/
+
+// Hokusai is a time series of count-min sketches, one per interval.
+type Hokusai struct {
+	w, d   int
+	t      int
+	levels []*Sketch
+}
+
+// NewHokusai returns a time series of sketches with the given width
+// and depth, remembering up to 2^(levels-1) past intervals.  The
+// width must be a power of two.
+func NewHokusai(w, d, levels int) *Hokusai {
+	if d < 1 || w < 1 || levels < 1 {
+		panic("Dimensions must be positive")
+	}
+	if w&(w-1) != 0 {
+		panic("width must be a power of two")
+	}
+
+	return &Hokusai{
+		w:      w,
+		d:      d,
+		levels: make([]*Sketch, levels),
+	}
+}
+
+func (h Hokusai) String() string {
+	return fmt.Sprintf("{Hokusai %dx%d, %d levels, %d intervals}",
+		h.w, h.d, len(h.levels), h.t)
+}
+
+// Intervals returns the number of intervals that have been pushed.
+func (h *Hokusai) Intervals() int {
+	return h.t
+}
+
+// Push adds the sketch for the next interval.  The sketch is copied,
+// so the caller may Reset and reuse it for the following interval.
+func (h *Hokusai) Push(s *Sketch) {
+	if s.d != h.d || s.w != h.w {
+		panic("Can't push a sketch with different dimensions")
+	}
+
+	h.t++
+
+	agg := s.Clone()
+	for j := range h.levels {
+		prev := h.levels[j]
+		h.levels[j] = agg
+
+		if j+1 == len(h.levels) || h.t%(1<<uint(j+1)) != 0 {
+			break
+		}
+
+		// The block for level j+1 is the one we just stored
+		// at level j together with the one it displaced.
+		agg = agg.Clone()
+		agg.Merge(prev)
+		if agg.w > 1 {
+			agg.Compress()
+		}
+	}
+}
+
+// Count returns the estimated count for the given input during
+// interval t, where the first pushed interval is 0.  Older intervals
+// are answered with the average over the block that contains them.
+// Intervals that haven't happened yet or have been forgotten count
+// as zero.
+func (h *Hokusai) Count(key string, t int) uint32 {
+	s := t + 1
+	if t < 0 || s > h.t {
+		return 0
+	}
+
+	for j, l := range h.levels {
+		if l == nil {
+			break
+		}
+		end := (h.t >> uint(j)) << uint(j)
+		start := end - (1 << uint(j)) + 1
+		if start <= s && s <= end {
+			return l.Count(key) >> uint(j)
+		}
+	}
+	return 0
+}
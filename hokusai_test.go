@@ -0,0 +1,64 @@
+package probably
+
+import (
+	"testing"
+)
+
+func TestHokusai(t *testing.T) {
+	h := NewHokusai(1024, 3, 4)
+
+	s := NewSketch(1024, 3)
+	for i := 0; i < 8; i++ {
+		s.Reset()
+		s.Add("hello", uint32(i))
+		s.Increment("there")
+		h.Push(s)
+	}
+
+	if h.Intervals() != 8 {
+		t.Fatalf("Expected 8 intervals, got %v", h.Intervals())
+	}
+
+	exp := []struct {
+		s string
+		t int
+		v uint32
+	}{
+		{"hello", 7, 7},  // level 0: exact
+		{"hello", 6, 6},  // level 1: (6+7)/2
+		{"hello", 4, 5},  // level 2: (4+5+6+7)/4
+		{"hello", 0, 3},  // level 3: (0+...+7)/8
+		{"there", 0, 1},  // level 3: 8/8
+		{"world", 7, 0},  // never seen
+		{"hello", 8, 0},  // hasn't happened yet
+		{"hello", -1, 0}, // before the start
+	}
+
+	for _, e := range exp {
+		if got := h.Count(e.s, e.t); got != e.v {
+			t.Errorf("Expected %v for %v at %v, got %v", e.v, e.s, e.t, got)
+		}
+	}
+
+	// The oldest level has been compressed three times.
+	if w := h.levels[3].w; w != 128 {
+		t.Errorf("Expected level 3 width 128, got %v", w)
+	}
+}
+
+func TestHokusaiForgets(t *testing.T) {
+	h := NewHokusai(64, 3, 2)
+
+	s := NewSketch(64, 3)
+	s.Increment("hello")
+	for i := 0; i < 5; i++ {
+		h.Push(s)
+	}
+
+	if got := h.Count("hello", 4); got != 1 {
+		t.Errorf("Expected 1 for the latest interval, got %v", got)
+	}
+	if got := h.Count("hello", 0); got != 0 {
+		t.Errorf("Expected forgotten interval to be 0, got %v", got)
+	}
+}
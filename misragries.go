@@ -0,0 +1,178 @@
+package probably
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+   The Misra-Gries summary is described in:
+   Jayadev Misra and David Gries. 1982. Finding repeated elements.
+   Science of Computer Programming 2(2).
+
+   Weighted updates and merging follow:
+   Pankaj K. Agarwal, Graham Cormode, Zengfeng Huang, Jeff M. Phillips,
+   Zhewei Wei and Ke Yi. 2012. Mergeable Summaries.
+   Proceedings of the 31st ACM Symposium on Principles of Database Systems.
+*/
+
+// MisraGries is a frequent items summary with deterministic
+// guarantees.  It keeps at most k counters; each counter is a lower
+// bound on its item's true count, and undercounts by no more than
+// (n-sum of counters)/(k+1) for a stream of total weight n.  Any item
+// with a true count over n/(k+1) is always reported.
+type MisraGries struct {
+	k        int
+	counters map[string]uint32
+	n        uint64
+	decr     uint32 // how much every counter has been decremented
+
+	scratch []uint32 // for selecting the (k+1)th largest counter
+}
+
+// NewMisraGries returns a summary keeping k counters.
+func NewMisraGries(k int) *MisraGries {
+	if k < 1 {
+		panic("k must be positive")
+	}
+	return &MisraGries{k: k, counters: make(map[string]uint32, k+1)}
+}
+
+func (m *MisraGries) String() string {
+	return fmt.Sprintf("{MisraGries %d/%d, n=%d}", len(m.counters), m.k, m.n)
+}
+
+// Add 'count' occurences of the given key.
+//
+// An Add costs O(1) amortized: reducing the counters costs O(k), but
+// removes at least k+1 from their total.
+func (m *MisraGries) Add(key string, count uint32) {
+	m.n += uint64(count)
+
+	if v, ok := m.counters[key]; ok || len(m.counters) < m.k {
+		m.counters[key] = v + count
+		return
+	}
+
+	if count == 1 {
+		// The classic update: a new key with nowhere to go cancels
+		// out with one occurence of every counted key.
+		m.subtract(1)
+		return
+	}
+
+	m.counters[key] = count
+	m.reduce()
+}
+
+// reduce subtracts the (k+1)th largest counter from every counter,
+// dropping those that reach zero, until at most k remain.
+func (m *MisraGries) reduce() {
+	vals := m.scratch[:0]
+	for _, v := range m.counters {
+		vals = append(vals, v)
+	}
+	m.scratch = vals
+
+	m.subtract(nthLargest(vals, m.k))
+}
+
+// subtract c from every counter, dropping those that reach zero.
+func (m *MisraGries) subtract(c uint32) {
+	for k, v := range m.counters {
+		if v <= c {
+			delete(m.counters, k)
+		} else {
+			m.counters[k] = v - c
+		}
+	}
+	m.decr += c
+}
+
+// nthLargest returns the nth largest (counting from zero) of vals,
+// which it reorders, in expected linear time.
+func nthLargest(vals []uint32, n int) uint32 {
+	lo, hi := 0, len(vals)-1
+	for lo < hi {
+		// Partition around the middle value, largest first.
+		p := vals[lo+(hi-lo)/2]
+		i, j := lo, hi
+		for i <= j {
+			for vals[i] > p {
+				i++
+			}
+			for vals[j] < p {
+				j--
+			}
+			if i <= j {
+				vals[i], vals[j] = vals[j], vals[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return vals[n]
+		}
+	}
+	return vals[n]
+}
+
+// N returns the total weight added to the summary.
+func (m *MisraGries) N() uint64 {
+	return m.n
+}
+
+// Bounds returns bounds on the true count of the given key.
+func (m *MisraGries) Bounds(key string) (lower, upper uint32) {
+	v := m.counters[key]
+	return v, v + m.decr
+}
+
+// GetTop returns the counted items, most frequent first.
+func (m *MisraGries) GetTop() []ItemCount {
+	rv := make([]ItemCount, 0, len(m.counters))
+	for k, v := range m.counters {
+		rv = append(rv, ItemCount{k, v})
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Count != rv[j].Count {
+			return rv[i].Count > rv[j].Count
+		}
+		return rv[i].Key < rv[j].Key
+	})
+	return rv
+}
+
+// GetBounds returns the counted items with bounds on their true
+// counts, most frequent first.
+func (m *MisraGries) GetBounds() []ItemBounds {
+	top := m.GetTop()
+	rv := make([]ItemBounds, len(top))
+	for i, ic := range top {
+		rv[i] = ItemBounds{ic.Key, ic.Count, ic.Count, ic.Count + m.decr}
+	}
+	return rv
+}
+
+// Merge the given summary into this one.  Both must keep the same
+// number of counters.
+func (m *MisraGries) Merge(from *MisraGries) {
+	if m.k != from.k {
+		panic("Can't merge summaries of different sizes")
+	}
+
+	for k, v := range from.counters {
+		m.counters[k] += v
+	}
+	m.n += from.n
+	m.decr += from.decr
+
+	if len(m.counters) > m.k {
+		m.reduce()
+	}
+}
@@ -0,0 +1,128 @@
+package probably
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestMisraGries(t *testing.T) {
+	m := NewMisraGries(2)
+
+	m.Add("a", 5)
+	m.Add("b", 3)
+	m.Add("c", 1)
+
+	exp := []ItemBounds{{"a", 4, 4, 5}, {"b", 2, 2, 3}}
+	got := m.GetBounds()
+	if len(got) != len(exp) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("Expected %v at %v, got %v", exp[i], i, got[i])
+		}
+	}
+
+	if lo, hi := m.Bounds("c"); lo != 0 || hi != 1 {
+		t.Errorf("Expected c in 0-1, got %v-%v", lo, hi)
+	}
+	if m.N() != 9 {
+		t.Errorf("Expected n=9, got %v", m.N())
+	}
+}
+
+func TestMisraGriesMerge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 999)
+
+	const k = 20
+	parts := []*MisraGries{NewMisraGries(k), NewMisraGries(k), NewMisraGries(k)}
+	exact := map[string]uint32{}
+	for i := 0; i < 30000; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		w := uint32(rnd.Intn(3) + 1)
+		parts[i%3].Add(key, w)
+		exact[key] += w
+	}
+
+	m := parts[0]
+	m.Merge(parts[1])
+	m.Merge(parts[2])
+
+	var n uint64
+	for key, v := range exact {
+		n += uint64(v)
+		lo, hi := m.Bounds(key)
+		if v < lo || v > hi {
+			t.Errorf("%v: true count %v outside %v-%v", key, v, lo, hi)
+		}
+	}
+	if m.N() != n {
+		t.Errorf("Expected n=%v, got %v", n, m.N())
+	}
+	for key, v := range exact {
+		if uint64(v) > n/(k+1) {
+			if lo, _ := m.Bounds(key); lo == 0 {
+				t.Errorf("%v occurs %v times but isn't reported", key, v)
+			}
+		}
+	}
+}
+
+func TestMisraGriesUnitWeights(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.2, 1, 99999)
+
+	const k, n = 50, 100000
+	m := NewMisraGries(k)
+	exact := map[string]uint32{}
+	for i := 0; i < n; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		m.Add(key, 1)
+		exact[key]++
+	}
+
+	for key, v := range exact {
+		lo, hi := m.Bounds(key)
+		if v < lo || v > hi {
+			t.Errorf("%v: true count %v outside %v-%v", key, v, lo, hi)
+		}
+		if v > n/(k+1) && lo == 0 {
+			t.Errorf("%v occurs %v times but isn't reported", key, v)
+		}
+	}
+}
+
+func TestNthLargest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		vals := make([]uint32, rnd.Intn(50)+1)
+		for j := range vals {
+			vals[j] = uint32(rnd.Intn(len(vals)/2 + 1))
+		}
+		sorted := append([]uint32(nil), vals...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+		n := rnd.Intn(len(vals))
+		if got := nthLargest(vals, n); got != sorted[n] {
+			t.Fatalf("Expected %v at %v of %v, got %v", sorted[n], n, sorted, got)
+		}
+	}
+}
+
+func BenchmarkMisraGriesAdd(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([]string, 1<<16)
+	for i := range keys {
+		keys[i] = strconv.Itoa(rnd.Int())
+	}
+	m := NewMisraGries(1000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.Add(keys[i%len(keys)], uint32(i%2+1))
+	}
+}
@@ -0,0 +1,251 @@
+//go:build unix
+
+package probably
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The file starts with a fixed header:
+//
+//	0  magic      "PRBSKTCH"
+//	8  version    uint32, little-endian
+//	12 byte order uint32 0x01020304 in the writer's native order
+//	16 width      uint64, little-endian
+//	24 depth      uint64, little-endian
+//
+// followed, at mappedHeaderSize, by the d row totals and then the w*d
+// counters, all uint32 in native byte order.
+const (
+	mappedMagic      = "PRBSKTCH"
+	mappedVersion    = 1
+	mappedByteOrder  = 0x01020304
+	mappedHeaderSize = 64
+)
+
+var errBadSketchFile = errors.New("not a sketch file")
+
+// MappedSketch is a Sketch whose counters live in a memory-mapped
+// file, so its counts survive restarts and can be shared with other
+// processes.
+//
+// Files use the native byte order and can't be moved between
+// machines of different endianness.  A mapped sketch can't be
+// folded, since that would change the file layout.
+type MappedSketch struct {
+	*Sketch
+	m mapping
+}
+
+// ReadOnlySketch is a sketch file mapped read-only.  Only queries are
+// available, since the mapping can't be written.
+type ReadOnlySketch struct {
+	sk *Sketch
+	m  mapping
+}
+
+type mapping struct {
+	f    *os.File
+	data []byte
+}
+
+// CreateSketchFile creates a new file at path holding an empty sketch
+// with the given width and depth, and maps it read-write.  It fails if
+// the file already exists.
+func CreateSketchFile(path string, w, d int) (*MappedSketch, error) {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	size := mappedHeaderSize + 4*int64(d) + 4*int64(w)*int64(d)
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	var hdr [mappedHeaderSize]byte
+	copy(hdr[:], mappedMagic)
+	binary.LittleEndian.PutUint32(hdr[8:], mappedVersion)
+	binary.NativeEndian.PutUint32(hdr[12:], mappedByteOrder)
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(w))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(d))
+	if _, err := f.WriteAt(hdr[:], 0); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	sk, m, err := mapSketch(f, false)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &MappedSketch{sk, m}, nil
+}
+
+// OpenSketchFile maps an existing sketch file read-write.
+func OpenSketchFile(path string) (*MappedSketch, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, m, err := mapSketch(f, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &MappedSketch{sk, m}, nil
+}
+
+// OpenSketchFileReadOnly maps an existing sketch file read-only.  Any
+// number of processes may do so, and see the counts as they're
+// updated by a writer.
+func OpenSketchFileReadOnly(path string) (*ReadOnlySketch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, m, err := mapSketch(f, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &ReadOnlySketch{sk, m}, nil
+}
+
+func mapSketch(f *os.File, readOnly bool) (*Sketch, mapping, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, mapping{}, err
+	}
+	if st.Size() < mappedHeaderSize {
+		return nil, mapping{}, errBadSketchFile
+	}
+
+	prot := syscall.PROT_READ
+	if !readOnly {
+		prot |= syscall.PROT_WRITE
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(st.Size()), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, mapping{}, err
+	}
+
+	if string(data[:8]) != mappedMagic {
+		syscall.Munmap(data)
+		return nil, mapping{}, errBadSketchFile
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v != mappedVersion {
+		syscall.Munmap(data)
+		return nil, mapping{}, fmt.Errorf("unsupported sketch file version %d", v)
+	}
+	if binary.NativeEndian.Uint32(data[12:]) != mappedByteOrder {
+		syscall.Munmap(data)
+		return nil, mapping{}, errors.New("sketch file has a different byte order")
+	}
+
+	// Check the dimensions against the file by dividing, since a
+	// corrupt header could make the product overflow.
+	w := binary.LittleEndian.Uint64(data[16:])
+	d := binary.LittleEndian.Uint64(data[24:])
+	n := uint64(len(data) - mappedHeaderSize)
+	words := n / 4
+	if w < 1 || d < 1 || n%4 != 0 || d > words || w > (words-d)/d || d+w*d != words {
+		syscall.Munmap(data)
+		return nil, mapping{}, errBadSketchFile
+	}
+
+	counters := unsafe.Slice((*uint32)(unsafe.Pointer(&data[mappedHeaderSize])), words)
+
+	sk := &Sketch{
+		w:         int(w),
+		d:         int(d),
+		rowCounts: counters[:d:d],
+		sk:        counters[d:],
+		fixed:     true,
+	}
+	return sk, mapping{f, data}, nil
+}
+
+func (m *mapping) close(sync bool) error {
+	var err error
+	if sync {
+		err = m.f.Sync()
+	}
+	if uerr := syscall.Munmap(m.data); err == nil {
+		err = uerr
+	}
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	m.data = nil
+	return err
+}
+
+// Sync flushes the counters to disk.  The counters survive a crash of
+// the process without this; Sync is for surviving a crash of the
+// machine.
+func (m *MappedSketch) Sync() error {
+	return m.m.f.Sync()
+}
+
+// Close syncs and unmaps the file and closes it.  The sketch must not
+// be used afterwards.
+func (m *MappedSketch) Close() error {
+	err := m.m.close(true)
+	m.Sketch = nil
+	return err
+}
+
+func (r *ReadOnlySketch) String() string {
+	return r.sk.String()
+}
+
+// Count returns the estimated count for the given input.
+func (r *ReadOnlySketch) Count(h string) uint32 {
+	return r.sk.Count(h)
+}
+
+// CountKey returns the estimated count for the input with the given Key.
+func (r *ReadOnlySketch) CountKey(k Key) uint32 {
+	return r.sk.CountKey(k)
+}
+
+// CountBatch appends the estimated count for each of the given inputs
+// to dst and returns the extended slice.
+func (r *ReadOnlySketch) CountBatch(hs []string, dst []uint32) []uint32 {
+	return r.sk.CountBatch(hs, dst)
+}
+
+// DistinctEstimate estimates how many distinct inputs have been added.
+func (r *ReadOnlySketch) DistinctEstimate() uint64 {
+	return r.sk.DistinctEstimate()
+}
+
+// Clone returns an in-memory copy of the sketch, which can be modified.
+func (r *ReadOnlySketch) Clone() *Sketch {
+	return r.sk.Clone()
+}
+
+// Close unmaps the file and closes it.  The sketch must not be used
+// afterwards.
+func (r *ReadOnlySketch) Close() error {
+	err := r.m.close(false)
+	r.sk = nil
+	return err
+}
@@ -0,0 +1,112 @@
+//go:build unix
+
+package probably
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedSketch(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "sketch")
+
+	m, err := CreateSketchFile(fn, 64, 4)
+	if err != nil {
+		t.Fatalf("Error creating sketch file: %v", err)
+	}
+	m.Add("hello", 2)
+	m.ConservativeIncrement("there")
+	if err := m.Close(); err != nil {
+		t.Fatalf("Error closing sketch file: %v", err)
+	}
+
+	if _, err := CreateSketchFile(fn, 64, 4); err == nil {
+		t.Errorf("Expected error creating over an existing file")
+	}
+
+	m, err = OpenSketchFile(fn)
+	if err != nil {
+		t.Fatalf("Error reopening sketch file: %v", err)
+	}
+	defer m.Close()
+
+	if m.String() != "{Sketch 64x4}" {
+		t.Errorf("Expected 64x4 sketch, got %v", m)
+	}
+	m.Increment("hello")
+
+	ro, err := OpenSketchFileReadOnly(fn)
+	if err != nil {
+		t.Fatalf("Error opening sketch file read-only: %v", err)
+	}
+	defer ro.Close()
+
+	exp := []struct {
+		s string
+		v uint32
+	}{
+		{"hello", 3},
+		{"there", 1},
+		{"world", 0},
+	}
+
+	for _, e := range exp {
+		if got := ro.Count(e.s); got != e.v {
+			t.Errorf("Expected %v for %v, got %v", e.v, e.s, got)
+		}
+	}
+
+	// Writes through one mapping are visible in the other.
+	m.Increment("world")
+	if got := ro.Count("world"); got != 1 {
+		t.Errorf("Expected 1 for world, got %v", got)
+	}
+	if got := ro.CountBatch([]string{"hello", "world"}, nil); got[0] != 3 || got[1] != 1 {
+		t.Errorf("Expected [3 1], got %v", got)
+	}
+	if ro.String() != m.String() {
+		t.Errorf("Expected %v read-only, got %v", m, ro)
+	}
+
+	// Clones live in memory and can be folded as usual.
+	c := ro.Clone()
+	c.Compress()
+	if c.Count("hello") != 3 {
+		t.Errorf("Expected 3 for hello in the clone, got %v", c.Count("hello"))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic folding a mapped sketch")
+		}
+	}()
+	m.Compress()
+}
+
+func TestMappedSketchBadFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "junk")
+	if err := os.WriteFile(fn, make([]byte, 128), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSketchFileReadOnly(fn); err != errBadSketchFile {
+		t.Errorf("Expected %v, got %v", errBadSketchFile, err)
+	}
+
+	// A header whose dimensions overflow to match the file's size.
+	hdr := make([]byte, mappedHeaderSize+4)
+	copy(hdr, mappedMagic)
+	binary.LittleEndian.PutUint32(hdr[8:], mappedVersion)
+	binary.NativeEndian.PutUint32(hdr[12:], mappedByteOrder)
+	binary.LittleEndian.PutUint64(hdr[16:], 1<<62)
+	binary.LittleEndian.PutUint64(hdr[24:], 1)
+	if err := os.WriteFile(fn, hdr, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSketchFileReadOnly(fn); err != errBadSketchFile {
+		t.Errorf("Expected %v for an overflowing header, got %v", errBadSketchFile, err)
+	}
+}
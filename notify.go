@@ -0,0 +1,184 @@
+package probably
+
+import (
+	"container/list"
+	"time"
+)
+
+// TopEventKind says how an item's place among the top items changed.
+type TopEventKind int
+
+const (
+	// Entered means the item started being tracked.
+	Entered TopEventKind = iota
+	// Evicted means the item stopped being tracked.
+	Evicted
+	// RankChanged means a tracked item moved up or down.
+	RankChanged
+)
+
+// TopEvent reports a change among the top items.
+type TopEvent struct {
+	Kind  TopEventKind
+	Key   string
+	Count uint32
+	Rank  int // position in GetTop, or -1 once evicted
+}
+
+// notifier keeps the tracked items in rank order and reports changes
+// once they've lasted long enough.
+type notifier struct {
+	f        func(TopEvent)
+	debounce time.Duration
+	now      func() time.Time
+
+	ranked   []*topItem     // the tracked items, in GetTop's order
+	reported map[string]int // the last rank reported for each item
+
+	changed map[string]*list.Element
+	queue   *list.List // of *pendingChange, least recently changed first
+}
+
+type pendingChange struct {
+	key   string
+	at    time.Time
+	count uint32 // as of its eviction
+}
+
+// Notify arranges for f to be called as items enter, leave or move
+// within the top items, or stops notifications if f is nil.  Items
+// already tracked are taken as known, and aren't reported.
+//
+// With a positive debounce, a change is only reported once the item
+// has gone that long without changing again, and is reported as the
+// difference from what was last reported, so an item that drops out
+// and comes back in the meantime isn't reported at all.  Changes are
+// checked for at the end of every Add and Merge, and by Flush.
+//
+// Keeping items in rank order costs time proportional to how far they
+// move, since an item that enters takes the evicted item's place.  A
+// Merge ranks everything afresh.
+func (s *StreamTop) Notify(f func(TopEvent), debounce time.Duration) {
+	if f == nil {
+		s.notify = nil
+		return
+	}
+
+	n := &notifier{
+		f:        f,
+		debounce: debounce,
+		now:      time.Now,
+		reported: make(map[string]int, len(s.heap)),
+		changed:  make(map[string]*list.Element),
+		queue:    list.New(),
+	}
+	n.rerank(s.heap.sorted())
+	for _, it := range n.ranked {
+		n.reported[it.key] = it.rank
+	}
+	s.notify = n
+}
+
+// Flush reports the changes that have lasted longer than the debounce.
+func (s *StreamTop) Flush() {
+	if s.notify != nil {
+		s.notify.flush(s.keys)
+	}
+}
+
+// note that key's place changed.
+func (n *notifier) note(key string) *pendingChange {
+	if e, ok := n.changed[key]; ok {
+		c := e.Value.(*pendingChange)
+		c.at = n.now()
+		n.queue.MoveToBack(e)
+		return c
+	}
+	c := &pendingChange{key: key, at: n.now()}
+	n.changed[key] = n.queue.PushBack(c)
+	return c
+}
+
+// rerank replaces the ranking, noting every item whose rank changed.
+func (n *notifier) rerank(ranked []*topItem) {
+	for _, it := range n.ranked {
+		it.rank = -1
+	}
+	for i, it := range ranked {
+		it.rank = i
+	}
+	for _, it := range n.ranked {
+		if it.rank == -1 {
+			n.note(it.key).count = it.count
+		}
+	}
+	for _, it := range ranked {
+		if r, ok := n.reported[it.key]; !ok || r != it.rank {
+			n.note(it.key)
+		}
+	}
+	n.ranked = ranked
+}
+
+func (n *notifier) swap(i, j int) {
+	a, b := n.ranked[i], n.ranked[j]
+	n.ranked[i], n.ranked[j] = b, a
+	a.rank, b.rank = j, i
+	n.note(a.key)
+	n.note(b.key)
+}
+
+// moved puts an item whose count changed back in rank order.
+func (n *notifier) moved(it *topItem) {
+	for it.rank > 0 && rankedBefore(it, n.ranked[it.rank-1]) {
+		n.swap(it.rank, it.rank-1)
+	}
+	for it.rank < len(n.ranked)-1 && rankedBefore(n.ranked[it.rank+1], it) {
+		n.swap(it.rank, it.rank+1)
+	}
+}
+
+func (n *notifier) entered(it *topItem) {
+	it.rank = len(n.ranked)
+	n.ranked = append(n.ranked, it)
+	n.note(it.key)
+	n.moved(it)
+}
+
+// replaced puts an item in the place of the one it evicted, so only
+// the items it moves past change rank.
+func (n *notifier) replaced(old, it *topItem) {
+	it.rank = old.rank
+	n.ranked[it.rank] = it
+	old.rank = -1
+	n.note(old.key).count = old.count
+	n.note(it.key)
+	n.moved(it)
+}
+
+// flush reports the changes that have settled.
+func (n *notifier) flush(keys map[string]*topItem) {
+	now := n.now()
+	for e := n.queue.Front(); e != nil; e = n.queue.Front() {
+		c := e.Value.(*pendingChange)
+		if now.Sub(c.at) < n.debounce {
+			break
+		}
+		n.queue.Remove(e)
+		delete(n.changed, c.key)
+
+		r, wasTracked := n.reported[c.key]
+		it, tracked := keys[c.key]
+		switch {
+		case tracked && !wasTracked:
+			n.reported[c.key] = it.rank
+			n.f(TopEvent{Entered, c.key, it.count, it.rank})
+		case !tracked && wasTracked:
+			delete(n.reported, c.key)
+			n.f(TopEvent{Evicted, c.key, c.count, -1})
+		case tracked && r != it.rank:
+			n.reported[c.key] = it.rank
+			n.f(TopEvent{RankChanged, c.key, it.count, it.rank})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package probably
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamTopNotify(t *testing.T) {
+	s := NewStreamTop(1024, 4, 2)
+	var got []TopEvent
+	s.Notify(func(e TopEvent) { got = append(got, e) }, 0)
+
+	expect := func(exp ...TopEvent) {
+		t.Helper()
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("Expected %v, got %v", exp, got)
+		}
+		got = nil
+	}
+
+	s.Add("a")
+	expect(TopEvent{Entered, "a", 1, 0})
+	s.Add("b")
+	expect(TopEvent{Entered, "b", 1, 1})
+	s.Add("b")
+	expect(TopEvent{RankChanged, "b", 2, 0}, TopEvent{RankChanged, "a", 1, 1})
+
+	// c isn't more frequent than a until its second occurence.
+	s.Add("c")
+	expect()
+	s.Add("c")
+	expect(TopEvent{Evicted, "a", 1, -1}, TopEvent{Entered, "c", 2, 1})
+
+	// Merging in a more frequent item pushes the others down.
+	o := NewStreamTop(1024, 4, 2)
+	o.AddN("d", 10)
+	s.Merge(o)
+	expect(TopEvent{Evicted, "c", 2, -1}, TopEvent{Entered, "d", 10, 0},
+		TopEvent{RankChanged, "b", 2, 1})
+
+	s.Notify(nil, 0)
+	s.AddN("e", 20)
+	expect()
+}
+
+func TestStreamTopNotifyDebounce(t *testing.T) {
+	s := NewStreamTop(1024, 4, 1)
+	var got []TopEvent
+	s.Notify(func(e TopEvent) { got = append(got, e) }, time.Minute)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.notify.now = func() time.Time { return now }
+
+	// x enters, and is pushed out by y before it's been a minute.
+	s.Add("x")
+	now = now.Add(10 * time.Second)
+	s.AddN("y", 2)
+	now = now.Add(20 * time.Second)
+	s.Flush()
+	if got != nil {
+		t.Errorf("Expected nothing yet, got %v", got)
+	}
+
+	now = now.Add(time.Minute)
+	s.Flush()
+	if exp := []TopEvent{{Entered, "y", 2, 0}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestStreamTopNotifyReplace(t *testing.T) {
+	s := NewStreamTopWith(NewExactCounter(), 100)
+	var got []TopEvent
+	s.Notify(func(e TopEvent) { got = append(got, e) }, time.Minute)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.notify.now = func() time.Time { return now }
+
+	for i := 0; i < 99; i++ {
+		s.AddN(fmt.Sprintf("b%02d", i), 3)
+	}
+	s.Add("z")
+	now = now.Add(time.Hour)
+	s.Flush()
+	got = nil
+
+	// a takes the place of z, the least frequent item, so nothing
+	// else moves.
+	s.AddN("a", 2)
+	if len(s.notify.changed) != 2 {
+		t.Errorf("Expected 2 pending changes, got %v", len(s.notify.changed))
+	}
+	now = now.Add(time.Hour)
+	s.Flush()
+	exp := []TopEvent{{Evicted, "z", 1, -1}, {Entered, "a", 2, 99}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,110 @@
+package probably
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how often each key may act within a sliding
+// window, using a pair of count-min sketches so memory stays bounded
+// no matter how many keys there are.
+//
+// One sketch counts the current window and the other the previous
+// one.  A key's rate is estimated as its count in the current window
+// plus its count in the previous window, weighted by how much of the
+// previous window still overlaps the sliding window.  That's the usual
+// approximate sliding window counter: it assumes the previous window's
+// events were spread evenly across it, so when they bunch up near its
+// end, a key can be allowed more than the limit within a window's
+// time.
+//
+// Since a count-min sketch never underestimates, collisions never let
+// a key through, but a key may be throttled early when it shares
+// counters with busy keys.  If the sketches have seen N events
+// in total, a key that is x events short of the limit is falsely
+// throttled with probability at most (N/(w·x))^d (Markov's inequality
+// on each of the d rows).  So with w=⌈ ℯ/𝜀 ⌉ and d=⌈ln (1/𝛿)⌉, a key
+// more than 𝜀N short of the limit is falsely throttled with
+// probability at most 𝛿.  Conservative updates make collisions
+// smaller still in practice.
+//
+// A RateLimiter is safe for concurrent use.
+type RateLimiter struct {
+	mu      sync.Mutex
+	cur     *Sketch
+	prev    *Sketch
+	limit   uint32
+	window  time.Duration
+	started time.Time
+}
+
+// NewRateLimiter returns a rate limiter that allows each key limit
+// actions per window, using sketches with the given width and depth.
+func NewRateLimiter(w, d int, limit uint32, window time.Duration) *RateLimiter {
+	if window <= 0 {
+		panic("Window must be positive")
+	}
+
+	return &RateLimiter{
+		cur:    NewSketch(w, d),
+		prev:   NewSketch(w, d),
+		limit:  limit,
+		window: window,
+	}
+}
+
+func (r *RateLimiter) String() string {
+	return fmt.Sprintf("{RateLimiter %d per %v, %v}", r.limit, r.window, r.cur)
+}
+
+// Allow reports whether the key may act now, and if so counts it.
+func (r *RateLimiter) Allow(key string) bool {
+	return r.AllowAt(key, time.Now())
+}
+
+// AllowAt reports whether the key may act at time t, and if so counts
+// it.  Times should not go backwards.
+func (r *RateLimiter) AllowAt(key string, t time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := r.advance(t)
+
+	k := NewKey(key)
+	overlap := 1 - float64(elapsed)/float64(r.window)
+	rate := float64(r.cur.CountKey(k)) + overlap*float64(r.prev.CountKey(k))
+	if rate >= float64(r.limit) {
+		return false
+	}
+
+	r.cur.ConservativeAddKey(k, 1)
+	return true
+}
+
+// advance rotates the sketches so that t falls in the current window,
+// and returns how far into that window t is.
+func (r *RateLimiter) advance(t time.Time) time.Duration {
+	if r.started.IsZero() {
+		r.started = t
+	}
+
+	elapsed := t.Sub(r.started)
+	if elapsed < r.window {
+		return elapsed
+	}
+
+	if elapsed < 2*r.window {
+		// Just moved into the next window.
+		r.cur, r.prev = r.prev, r.cur
+		r.cur.Reset()
+	} else {
+		// Nothing recent enough to remember.
+		r.cur.Reset()
+		r.prev.Reset()
+	}
+
+	elapsed %= r.window
+	r.started = t.Add(-elapsed)
+	return elapsed
+}
@@ -0,0 +1,44 @@
+package probably
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := NewRateLimiter(1024, 4, 10, time.Minute)
+
+	t0 := time.Unix(1000000, 0)
+
+	for i := 0; i < 10; i++ {
+		if !r.AllowAt("hello", t0.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("Expected action %v to be allowed", i)
+		}
+	}
+	if r.AllowAt("hello", t0.Add(10*time.Second)) {
+		t.Errorf("Expected the 11th action to be throttled")
+	}
+	if !r.AllowAt("there", t0.Add(10*time.Second)) {
+		t.Errorf("Expected another key to be allowed")
+	}
+
+	// Halfway through the next window, half the previous window's
+	// actions still count against the limit.
+	half := t0.Add(90 * time.Second)
+	for i := 0; i < 5; i++ {
+		if !r.AllowAt("hello", half) {
+			t.Fatalf("Expected action %v in the next window to be allowed", i)
+		}
+	}
+	if r.AllowAt("hello", half) {
+		t.Errorf("Expected the sliding window to throttle")
+	}
+
+	// After a long quiet spell everything is forgotten.
+	later := t0.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		if !r.AllowAt("hello", later) {
+			t.Fatalf("Expected action %v after an hour to be allowed", i)
+		}
+	}
+}
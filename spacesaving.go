@@ -0,0 +1,251 @@
+package probably
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+   Space-Saving is described in:
+   Ahmed Metwally, Divyakant Agrawal and Amr El Abbadi. 2005.
+   Efficient Computation of Frequent and Top-k Elements in Data Streams.
+   Proceedings of the 10th International Conference on Database Theory.
+
+   Merging follows the combine step of:
+   Massimo Cafaro, Marco Pulimeno and Piergiulio Tempesta. 2016.
+   A parallel space saving algorithm for frequent items and the
+   Hurwitz zeta distribution.  Information Sciences 329.
+   https://arxiv.org/abs/1401.0702
+*/
+
+// ssBucket holds all the monitored items with the same count.  Buckets
+// form a list in increasing order of count, and each bucket has a
+// circular list of its items.
+type ssBucket struct {
+	count      uint32
+	prev, next *ssBucket
+	items      *ssItem
+}
+
+type ssItem struct {
+	key        string
+	err        uint32
+	bucket     *ssBucket
+	prev, next *ssItem
+}
+
+// SpaceSaving tracks the top items in a stream in a fixed number of
+// counters.  Every monitored item's count is an overestimate by at
+// most its recorded error, and any item that occurs more than n/k
+// times in a stream of n items is guaranteed to be monitored.
+type SpaceSaving struct {
+	k     int
+	items map[string]*ssItem
+	min   *ssBucket // the bucket with the smallest count
+}
+
+// NewSpaceSaving returns a Space-Saving counter monitoring k items.
+func NewSpaceSaving(k int) *SpaceSaving {
+	if k < 1 {
+		panic("k must be positive")
+	}
+	return &SpaceSaving{k: k, items: make(map[string]*ssItem, k)}
+}
+
+func (s *SpaceSaving) String() string {
+	return fmt.Sprintf("{SpaceSaving %d/%d}", len(s.items), s.k)
+}
+
+// unlink removes the item from its bucket, dropping the bucket if it
+// is left empty.
+func (s *SpaceSaving) unlink(it *ssItem) {
+	b := it.bucket
+	if it.next == it {
+		b.items = nil
+		if b.prev != nil {
+			b.prev.next = b.next
+		} else {
+			s.min = b.next
+		}
+		if b.next != nil {
+			b.next.prev = b.prev
+		}
+	} else {
+		it.prev.next = it.next
+		it.next.prev = it.prev
+		if b.items == it {
+			b.items = it.next
+		}
+	}
+	it.bucket = nil
+}
+
+// link adds the item to bucket b.
+func (s *SpaceSaving) link(it *ssItem, b *ssBucket) {
+	it.bucket = b
+	if b.items == nil {
+		it.prev, it.next = it, it
+		b.items = it
+		return
+	}
+	it.next = b.items
+	it.prev = b.items.prev
+	it.prev.next = it
+	it.next.prev = it
+}
+
+// increment moves the item up to the bucket for count+1, in O(1).
+func (s *SpaceSaving) increment(it *ssItem) {
+	b := it.bucket
+	count := b.count + 1
+
+	next := b.next
+	if next == nil || next.count != count {
+		nb := &ssBucket{count: count, prev: b, next: next}
+		if next != nil {
+			next.prev = nb
+		}
+		b.next = nb
+		next = nb
+	}
+
+	s.unlink(it)
+	s.link(it, next)
+}
+
+// Add an item to the stream counter.
+func (s *SpaceSaving) Add(key string) {
+	if it, ok := s.items[key]; ok {
+		s.increment(it)
+		return
+	}
+
+	if len(s.items) < s.k {
+		it := &ssItem{key: key}
+		if s.min == nil || s.min.count != 0 {
+			// A bucket for count zero, incremented right away.
+			b := &ssBucket{next: s.min}
+			if s.min != nil {
+				s.min.prev = b
+			}
+			s.min = b
+		}
+		s.link(it, s.min)
+		s.items[key] = it
+		s.increment(it)
+		return
+	}
+
+	// Replace an item with the smallest count, inheriting its count
+	// as the error.
+	it := s.min.items
+	delete(s.items, it.key)
+	it.key = key
+	it.err = s.min.count
+	s.items[key] = it
+	s.increment(it)
+}
+
+// Count returns the estimated count for the given key and the most it
+// might be overestimated by.  Unmonitored keys occur at most as often
+// as the smallest monitored count, returned as the error with a count
+// of zero.
+func (s *SpaceSaving) Count(key string) (count, err uint32) {
+	if it, ok := s.items[key]; ok {
+		return it.bucket.count, it.err
+	}
+	if len(s.items) < s.k || s.min == nil {
+		return 0, 0
+	}
+	return 0, s.min.count
+}
+
+// GetTop returns the monitored items, most frequent first.
+func (s *SpaceSaving) GetTop() []ItemCount {
+	rv := make([]ItemCount, 0, len(s.items))
+	for k, it := range s.items {
+		rv = append(rv, ItemCount{k, it.bucket.count})
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Count != rv[j].Count {
+			return rv[i].Count > rv[j].Count
+		}
+		return rv[i].Key < rv[j].Key
+	})
+	return rv
+}
+
+// Merge the given counter into this one.  Both must monitor the same
+// number of items.
+//
+// Each item's merged count is the sum of its counts in both, where an
+// item missing from a full counter is taken to have that counter's
+// smallest count.  The k largest are kept.
+//
+// The guarantees still hold for the combined stream of n items.  An
+// item missing from a counter occurred there at most that counter's
+// smallest count, so the merged count is still an overestimate, by at
+// most the sum of the two smallest counts, which is at most n/k.  Every
+// merged count is at least that sum too, so an item that isn't kept
+// occurred no more often than the new smallest count.
+func (s *SpaceSaving) Merge(from *SpaceSaving) {
+	if s.k != from.k {
+		panic("Can't merge counters of different sizes")
+	}
+
+	floor := func(ss *SpaceSaving) uint32 {
+		if len(ss.items) < ss.k || ss.min == nil {
+			return 0
+		}
+		return ss.min.count
+	}
+	sFloor, fFloor := floor(s), floor(from)
+
+	type merged struct {
+		key        string
+		count, err uint32
+	}
+	all := make([]merged, 0, len(s.items)+len(from.items))
+	for k, it := range s.items {
+		m := merged{k, it.bucket.count, it.err}
+		if o, ok := from.items[k]; ok {
+			m.count += o.bucket.count
+			m.err += o.err
+		} else {
+			m.count += fFloor
+			m.err += fFloor
+		}
+		all = append(all, m)
+	}
+	for k, it := range from.items {
+		if _, ok := s.items[k]; !ok {
+			all = append(all, merged{k, it.bucket.count + sFloor, it.err + sFloor})
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].count < all[j].count
+	})
+	if len(all) > s.k {
+		all = all[len(all)-s.k:]
+	}
+
+	// Rebuild the stream summary, smallest counts first.
+	s.items = make(map[string]*ssItem, s.k)
+	s.min = nil
+	var last *ssBucket
+	for _, m := range all {
+		if last == nil || last.count != m.count {
+			b := &ssBucket{count: m.count, prev: last}
+			if last != nil {
+				last.next = b
+			} else {
+				s.min = b
+			}
+			last = b
+		}
+		it := &ssItem{key: m.key, err: m.err}
+		s.link(it, last)
+		s.items[m.key] = it
+	}
+}
@@ -0,0 +1,109 @@
+package probably
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := NewSpaceSaving(3)
+
+	for _, k := range []string{"a", "b", "a", "c", "a", "b"} {
+		s.Add(k)
+	}
+
+	exp := []ItemCount{{"a", 3}, {"b", 2}, {"c", 1}}
+	for i, ic := range s.GetTop() {
+		if ic != exp[i] {
+			t.Errorf("Expected %v at %v, got %v", exp[i], i, ic)
+		}
+	}
+
+	// d replaces c, the only item with the smallest count.
+	s.Add("d")
+	if c, err := s.Count("d"); c != 2 || err != 1 {
+		t.Errorf("Expected d to be 2±1, got %v±%v", c, err)
+	}
+	if c, err := s.Count("c"); c != 0 || err != 2 {
+		t.Errorf("Expected c to be at most 2, got %v±%v", c, err)
+	}
+}
+
+func TestSpaceSavingGuarantees(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 999)
+
+	const k = 20
+	s := NewSpaceSaving(k)
+	exact := map[string]uint32{}
+	n := 20000
+	for i := 0; i < n; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		s.Add(key)
+		exact[key]++
+	}
+
+	for _, ic := range s.GetTop() {
+		_, err := s.Count(ic.Key)
+		if ic.Count < exact[ic.Key] || ic.Count-err > exact[ic.Key] {
+			t.Errorf("%v: true count %v outside %v-%v", ic.Key, exact[ic.Key], ic.Count-err, ic.Count)
+		}
+	}
+	for key, v := range exact {
+		if v > uint32(n/k) {
+			if c, _ := s.Count(key); c == 0 {
+				t.Errorf("%v occurs %v times but isn't monitored", key, v)
+			}
+		}
+	}
+}
+
+func TestSpaceSavingMerge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 999)
+
+	const k = 20
+	a, b := NewSpaceSaving(k), NewSpaceSaving(k)
+	exact := map[string]uint32{}
+	for i := 0; i < 20000; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		if i%3 == 0 {
+			a.Add(key)
+		} else {
+			b.Add(key)
+		}
+		exact[key]++
+	}
+
+	a.Merge(b)
+
+	top := a.GetTop()
+	if len(top) != k {
+		t.Fatalf("Expected %v items after merging, got %v", k, len(top))
+	}
+	for _, ic := range top {
+		_, err := a.Count(ic.Key)
+		if ic.Count < exact[ic.Key] || ic.Count-err > exact[ic.Key] {
+			t.Errorf("%v: true count %v outside %v-%v", ic.Key, exact[ic.Key], ic.Count-err, ic.Count)
+		}
+	}
+	for key, v := range exact {
+		if c, err := a.Count(key); err > 20000/k {
+			t.Errorf("%v: error %v is more than n/k", key, err)
+		} else if c == 0 && v > err {
+			t.Errorf("%v: unmonitored with true count %v above %v", key, v, err)
+		}
+	}
+	if top[0].Key != "0" {
+		t.Errorf("Expected 0 to be the most frequent, got %v", top[0])
+	}
+
+	// The merged summary still supports updates.
+	for i := 0; i < 1000; i++ {
+		a.Add("new")
+	}
+	if c, _ := a.Count("new"); c < 1000 {
+		t.Errorf("Expected at least 1000 for new, got %v", c)
+	}
+}
@@ -1,6 +1,8 @@
 package probably
 
 import (
+	"container/heap"
+	"math"
 	"sort"
 )
 
@@ -8,12 +10,81 @@ const initialThresh = 0
 
 // StreamTop tracks the top-n items in a stream.
 type StreamTop struct {
-	sk       *Sketch
+	est FrequencyEstimator
+	topSet
+}
+
+// topSet is a bounded set of candidates for the top items of a stream,
+// by their estimated counts.
+type topSet struct {
 	thresh   uint32
-	trimTo   int
 	maxItems int
 
-	keys map[string]uint32
+	keys map[string]*topItem
+	heap topHeap // the tracked items, least frequent first
+
+	n         uint64 // total occurences added
+	maxThresh uint32 // the highest thresh so far
+
+	notify *notifier // nil unless notifications are wanted
+}
+
+// topItem is what's known about a tracked item.
+type topItem struct {
+	key   string
+	count uint32 // the sketch's estimate as of its last occurence
+	seen  uint32 // occurences since it started being tracked
+	prior uint32 // most occurences it could have had before that
+	index int    // position in the heap
+	rank  int    // position in GetTop, when notifying
+}
+
+// topHeap is a min-heap of tracked items in the reverse of GetTop's
+// order, so ties are evicted consistently.
+type topHeap []*topItem
+
+func (h topHeap) Len() int {
+	return len(h)
+}
+
+func (h topHeap) Less(i, j int) bool {
+	return rankedBefore(h[j], h[i])
+}
+
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topHeap) Push(x interface{}) {
+	it := x.(*topItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
+
+// sorted returns the items, most frequent first.
+func (h topHeap) sorted() []*topItem {
+	rv := make([]*topItem, len(h))
+	copy(rv, h)
+	sort.Slice(rv, func(i, j int) bool { return rankedBefore(rv[i], rv[j]) })
+	return rv
+}
+
+// rankedBefore reports whether a comes before b in GetTop.
+func rankedBefore(a, b *topItem) bool {
+	if a.count != b.count {
+		return a.count > b.count
+	}
+	return a.key < b.key
 }
 
 // ItemCount represents an item with its count
@@ -22,95 +93,322 @@ type ItemCount struct {
 	Count uint32
 }
 
+// ItemBounds represents an item with its estimated count and bounds
+// on its true count
+type ItemBounds struct {
+	Key          string
+	Count        uint32
+	Lower, Upper uint32
+}
+
 // NewStreamTop returns an estimator for the 'maxItems' in the stream.  It uses
 // a count-min sketch, which is created with width w and depth d.
 func NewStreamTop(w, d, maxItems int) *StreamTop {
+	return NewStreamTopWith(ConservativeSketch{NewSketch(w, d)}, maxItems)
+}
+
+// NewStreamTopWith returns an estimator for the 'maxItems' in the
+// stream, using the given estimator for the items' counts.
+func NewStreamTopWith(est FrequencyEstimator, maxItems int) *StreamTop {
 	return &StreamTop{
-		NewSketch(w, d),
-		initialThresh,
-		int(float64(maxItems) * 0.75),
-		maxItems,
-		make(map[string]uint32),
+		est:    est,
+		topSet: newTopSet(maxItems),
 	}
 }
 
-type trimSlice struct {
-	keys []string
-	st   *StreamTop
+// sketch returns the Sketch behind the estimator, if there is one.
+func (s *StreamTop) sketch() *Sketch {
+	if e, ok := s.est.(interface{ sketch() *Sketch }); ok {
+		return e.sketch()
+	}
+	return nil
 }
 
-func (p trimSlice) Len() int {
-	return len(p.keys)
+func newTopSet(maxItems int) topSet {
+	return topSet{
+		thresh:   initialThresh,
+		maxItems: maxItems,
+		keys:     make(map[string]*topItem),
+	}
 }
 
-func (p trimSlice) Less(i, j int) bool {
-	return p.st.keys[p.keys[i]] > p.st.keys[p.keys[j]]
+// reset clears the stream counter, and reports whether it could,
+// which it can't if the estimator can't be reset.
+func (s *StreamTop) reset() bool {
+	r, ok := s.est.(resetter)
+	if !ok {
+		return false
+	}
+	r.Reset()
+	s.topSet = newTopSet(s.maxItems)
+	return true
 }
 
-func (p trimSlice) Swap(i, j int) {
-	p.keys[i], p.keys[j] = p.keys[j], p.keys[i]
+// trim drops the least frequent items until at most maxItems are
+// left, and raises the threshold for tracking new items to match.
+func (s *topSet) trim() {
+	for len(s.heap) > s.maxItems {
+		it := heap.Pop(&s.heap).(*topItem)
+		delete(s.keys, it.key)
+		s.evicted(it.count)
+	}
 }
 
-func (s *StreamTop) getTrimSlice() *trimSlice {
-	ts := trimSlice{make([]string, 0, len(s.keys)), s}
-	for k := range s.keys {
-		ts.keys = append(ts.keys, k)
+// evicted notes that an item with the given count stopped being
+// tracked.
+func (s *topSet) evicted(count uint32) {
+	if count > s.thresh {
+		s.thresh = count
+	}
+	if s.thresh > s.maxThresh {
+		s.maxThresh = s.thresh
 	}
-	sort.Sort(&ts)
-	return &ts
 }
 
-func (s *StreamTop) trim() {
-	ts := s.getTrimSlice()
+// Add an item to the stream counter.
+//
+// Tracked items are kept in a min-heap, so an Add costs O(log
+// maxItems): once the heap is full, a new item that's more frequent
+// than the least frequent tracked item takes its place.
+func (s *StreamTop) Add(v string) {
+	s.AddN(v, 1)
+}
 
-	s.thresh = s.keys[ts.keys[s.trimTo]]
+// AddN adds count occurences of an item to the stream counter.
+func (s *StreamTop) AddN(v string, count uint32) {
+	est := s.est.Add(v, count)
+	if d, ok := s.est.(decayer); ok && d.Decays() {
+		s.refreshMin()
+	}
+	s.offer(v, est, count)
+	if s.notify != nil {
+		s.notify.flush(s.keys)
+	}
+}
 
-	did := 0
-	for k, v := range s.keys {
-		if v <= s.thresh {
-			did++
-			delete(s.keys, k)
+// refreshMin brings the least frequent item's count up to date, for
+// estimators whose counts go down over time, and lowers the threshold
+// to match, since whatever was evicted will have gone down as well.
+//
+// Only one item is refreshed per Add, so if another item becomes the
+// least frequent, it's refreshed on the next.
+func (s *StreamTop) refreshMin() {
+	if len(s.heap) == 0 {
+		return
+	}
+	min := s.heap[0]
+	if c := s.est.Count(min.key); c != min.count {
+		min.count = c
+		heap.Fix(&s.heap, 0)
+		if s.notify != nil {
+			s.notify.moved(min)
 		}
 	}
+	if s.heap[0].count < s.thresh {
+		s.thresh = s.heap[0].count
+	}
 }
 
-// Add an item to the stream counter.
-func (s *StreamTop) Add(v string) {
-	count := s.sk.ConservativeIncrement(v)
-	if count > s.thresh {
-		s.keys[v] = count
+// offer notes count more occurences of an item, now estimated at est,
+// and tracks it if it's among the most frequent.
+func (s *topSet) offer(v string, est, count uint32) {
+	s.n += uint64(count)
+
+	if it, ok := s.keys[v]; ok {
+		it.count = est
+		it.seen += count
+		heap.Fix(&s.heap, it.index)
+		if s.notify != nil {
+			s.notify.moved(it)
+		}
+		return
 	}
-	if len(s.keys) > s.maxItems {
-		s.trim()
+
+	if est <= s.thresh {
+		return
+	}
+
+	// Every earlier occurence of an untracked item was either below
+	// the threshold or evicted below it.
+	it := &topItem{key: v, count: est, seen: count, prior: s.maxThresh}
+
+	if len(s.heap) < s.maxItems {
+		s.keys[v] = it
+		heap.Push(&s.heap, it)
+		if s.notify != nil {
+			s.notify.entered(it)
+		}
+		return
+	}
+
+	if len(s.heap) == 0 || est <= s.heap[0].count {
+		// Not tracking it counts as evicting it.
+		s.evicted(est)
+		return
+	}
+	min := s.heap[0]
+	delete(s.keys, min.key)
+	s.evicted(min.count)
+
+	it.index = 0
+	s.heap[0] = it
+	s.keys[v] = it
+	heap.Fix(&s.heap, 0)
+	if s.notify != nil {
+		s.notify.replaced(min, it)
+	}
+}
+
+// AddBatch adds pre-aggregated counts to the stream counter.
+func (s *StreamTop) AddBatch(items []ItemCount) {
+	for _, ic := range items {
+		s.AddN(ic.Key, ic.Count)
 	}
 }
 
 // GetTop returns the top items from the stream
 func (s StreamTop) GetTop() []ItemCount {
-	ts := s.getTrimSlice()
-	rv := make([]ItemCount, 0, len(s.keys))
-	for _, k := range ts.keys {
-		rv = append(rv, ItemCount{k, s.keys[k]})
+	return s.top()
+}
+
+func (s *topSet) top() []ItemCount {
+	rv := make([]ItemCount, 0, len(s.heap))
+	for _, it := range s.heap.sorted() {
+		rv = append(rv, ItemCount{it.key, it.count})
 	}
 	return rv
 }
 
-// Merge the given stream into this one.
+// noise returns how much the estimator could have inflated a count.
+// Without a known bound, it could be the whole stream.
+func (s *StreamTop) noise() uint32 {
+	if b, ok := s.est.(errorBounder); ok {
+		return b.ErrorBound(s.n)
+	}
+	if s.n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(s.n)
+}
+
+func (s *StreamTop) bounds(it *topItem, noise uint32) ItemBounds {
+	b := ItemBounds{it.key, it.count, it.seen, it.count}
+
+	// Everything since it was tracked was seen exactly.
+	if upper := it.seen + it.prior; upper < b.Upper {
+		b.Upper = upper
+	}
+	if it.count > noise && it.count-noise > b.Lower {
+		b.Lower = it.count - noise
+	}
+	return b
+}
+
+// GetBounds returns the top items from the stream, with bounds on
+// their true counts.
+//
+// The upper bound always holds, since the sketch never undercounts.
+// The lower bound is at least the occurences seen since the item was
+// tracked, and beyond that relies on the sketch's error bound, so it
+// holds with probability 1-𝛿 for a sketch of depth ⌈ln (1/𝛿)⌉.
+//
+// Both rely on the estimator never undercounting, so they don't hold
+// for a CountSketch or DecayedFrequency.
+func (s *StreamTop) GetBounds() []ItemBounds {
+	noise := s.noise()
+	rv := make([]ItemBounds, 0, len(s.heap))
+	for _, it := range s.heap.sorted() {
+		rv = append(rv, s.bounds(it, noise))
+	}
+	return rv
+}
+
+// GetGuaranteedTop returns the items that are certainly among the top
+// k of the stream, within the confidence of GetBounds: those whose
+// lower bound is beaten by fewer than k other items' upper bounds,
+// including the bound on every untracked item.
+func (s *StreamTop) GetGuaranteedTop(k int) []ItemBounds {
+	all := s.GetBounds()
+
+	uppers := make([]uint32, len(all))
+	for i, b := range all {
+		uppers[i] = b.Upper
+	}
+	sort.Slice(uppers, func(i, j int) bool { return uppers[i] > uppers[j] })
+
+	var rv []ItemBounds
+	for _, b := range all {
+		if s.maxThresh > b.Lower {
+			continue
+		}
+		beaten := sort.Search(len(uppers), func(i int) bool {
+			return uppers[i] <= b.Lower
+		})
+		if b.Upper > b.Lower {
+			beaten-- // itself
+		}
+		if beaten < k {
+			rv = append(rv, b)
+		}
+	}
+	return rv
+}
+
+// Merge the given stream into this one.  The estimators must be
+// mergeable with each other, unless this one can't merge at all, in
+// which case only the counts of from's tracked items are added.
 func (s *StreamTop) Merge(from *StreamTop) {
-	s.sk.Merge(from.sk)
+	mergeFrequency(s.est, from.est, from.keys)
 
-	d := map[string]uint32{}
+	d := make(map[string]*topItem, len(s.keys)+len(from.keys))
+	h := make(topHeap, 0, len(s.keys)+len(from.keys))
 
-	for k := range s.keys {
-		d[k] = s.sk.Count(k)
+	// An item missing from either side had at most that side's
+	// maxThresh occurences there.
+	for k, it := range s.keys {
+		seen, prior := uint32(0), from.maxThresh
+		if o, ok := from.keys[k]; ok {
+			seen, prior = o.seen, o.prior
+		}
+		m := &topItem{key: k, count: s.est.Count(k), seen: it.seen + seen, prior: it.prior + prior}
+		d[k] = m
+		h.Push(m)
 	}
-	for k := range from.keys {
-		d[k] = s.sk.Count(k)
+	for k, it := range from.keys {
+		if _, ok := s.keys[k]; !ok {
+			m := &topItem{key: k, count: s.est.Count(k), seen: it.seen, prior: it.prior + s.maxThresh}
+			d[k] = m
+			h.Push(m)
+		}
 	}
+	heap.Init(&h)
 
 	s.keys = d
+	s.heap = h
+	s.n += from.n
+	s.maxThresh += from.maxThresh
+
+	s.trim()
 
-	if len(s.keys) > s.maxItems {
-		s.trim()
+	if s.notify != nil {
+		// Everything may have moved, so rank it all afresh.
+		s.notify.rerank(s.heap.sorted())
+		s.notify.flush(s.keys)
+	}
+}
+
+// mergeFrequency merges the counts in from into to.  If to can't
+// merge estimators at all, from's tracked items are added to it with
+// their counts instead, which is the best that can be done.
+func mergeFrequency(to, from FrequencyEstimator, items map[string]*topItem) {
+	m, ok := to.(frequencyMerger)
+	if !ok {
+		for k, it := range items {
+			to.Add(k, it.count)
+		}
+		return
+	}
+	if err := m.MergeFrequency(from); err != nil {
+		panic("Can't merge these estimators")
 	}
 }
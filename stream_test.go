@@ -0,0 +1,247 @@
+package probably
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStreamTop(t *testing.T) {
+	s := NewStreamTop(1024, 4, 8)
+
+	for i := 0; i < 20; i++ {
+		for j := 0; j <= i; j++ {
+			s.Add(strconv.Itoa(i))
+		}
+	}
+
+	top := s.GetTop()
+	if len(top) == 0 || len(top) > 8 {
+		t.Fatalf("Expected up to 8 items, got %v", top)
+	}
+	for i, ic := range top[:3] {
+		exp := ItemCount{strconv.Itoa(19 - i), uint32(20 - i)}
+		if ic != exp {
+			t.Errorf("Expected %v at %v, got %v", exp, i, ic)
+		}
+	}
+}
+
+func TestStreamTopNoItems(t *testing.T) {
+	s := NewStreamTop(1024, 4, 0)
+	s.Add("a")
+	s.AddN("b", 3)
+	s.Merge(NewStreamTop(1024, 4, 0))
+
+	if top := s.GetTop(); len(top) != 0 {
+		t.Errorf("Expected no items, got %v", top)
+	}
+}
+
+func TestStreamTopMergeTies(t *testing.T) {
+	// Of items with the same count, the ones GetTop ranks last are
+	// evicted, whatever order the merge sees them in.
+	for i := 0; i < 20; i++ {
+		a := NewStreamTopWith(NewExactCounter(), 2)
+		b := NewStreamTopWith(NewExactCounter(), 2)
+		a.AddN("b", 2)
+		a.AddN("d", 2)
+		b.AddN("a", 2)
+		b.AddN("c", 2)
+		a.Merge(b)
+
+		exp := []ItemCount{{"a", 2}, {"b", 2}}
+		if !reflect.DeepEqual(a.GetTop(), exp) {
+			t.Fatalf("Expected %v, got %v", exp, a.GetTop())
+		}
+	}
+}
+
+func TestStreamTopAddN(t *testing.T) {
+	a, b := NewStreamTop(1024, 4, 8), NewStreamTop(1024, 4, 8)
+
+	var batch []ItemCount
+	for i := 0; i < 20; i++ {
+		for j := 0; j <= i; j++ {
+			a.Add(strconv.Itoa(i))
+		}
+		batch = append(batch, ItemCount{strconv.Itoa(i), uint32(i + 1)})
+	}
+	b.AddBatch(batch)
+
+	if !reflect.DeepEqual(a.GetTop(), b.GetTop()) {
+		t.Errorf("Expected %v, got %v", a.GetTop(), b.GetTop())
+	}
+	if a.n != b.n {
+		t.Errorf("Expected %v added, got %v", a.n, b.n)
+	}
+
+	// Weighted updates keep the bounds honest.
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 9999)
+	s := NewStreamTop(2048, 4, 40)
+	exact := map[string]uint32{}
+	for i := 0; i < 10000; i++ {
+		k := strconv.FormatUint(zipf.Uint64(), 10)
+		n := uint32(rnd.Intn(10) + 1)
+		s.AddN(k, n)
+		exact[k] += n
+	}
+	for _, ib := range s.GetBounds() {
+		if v := exact[ib.Key]; v < ib.Lower || v > ib.Upper {
+			t.Errorf("%v: true count %v outside %v-%v", ib.Key, v, ib.Lower, ib.Upper)
+		}
+	}
+	if top := s.GetTop(); top[0].Key != "0" {
+		t.Errorf("Expected 0 on top, got %v", top[0])
+	}
+}
+
+func TestStreamTopMarshal(t *testing.T) {
+	s := NewStreamTop(1024, 4, 8)
+	for i := 0; i < 20; i++ {
+		for j := 0; j <= i; j++ {
+			s.Add(strconv.Itoa(i))
+		}
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+
+	var got StreamTop
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error unmarshaling: %v", err)
+	}
+	// The heap may be laid out differently, but should hold the
+	// same items.
+	if !reflect.DeepEqual(s.est, got.est) ||
+		!reflect.DeepEqual(s.GetBounds(), got.GetBounds()) ||
+		s.thresh != got.thresh ||
+		s.maxItems != got.maxItems || s.n != got.n ||
+		s.maxThresh != got.maxThresh {
+		t.Errorf("Expected %+v, got %+v", s, got)
+	}
+
+	// Shipping half the work elsewhere and merging it back gives the
+	// same answers as doing it all in one place.
+	a, b := NewStreamTop(1024, 4, 8), NewStreamTop(1024, 4, 8)
+	for i := 0; i < 20; i++ {
+		for j := 0; j <= i; j++ {
+			if j%2 == 0 {
+				a.Add(strconv.Itoa(i))
+			} else {
+				b.Add(strconv.Itoa(i))
+			}
+		}
+	}
+	data, _ = b.MarshalBinary()
+	var shipped StreamTop
+	if err := shipped.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error unmarshaling: %v", err)
+	}
+	a.Merge(&shipped)
+
+	if a.GetTop()[0] != s.GetTop()[0] {
+		t.Errorf("Expected %v on top after merging, got %v", s.GetTop()[0], a.GetTop()[0])
+	}
+
+	for i := 0; i < len(data); i++ {
+		if err := shipped.UnmarshalBinary(data[:i]); err == nil {
+			t.Fatalf("Expected error unmarshaling %v of %v bytes", i, len(data))
+		}
+	}
+}
+
+func TestStreamTopBounds(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, 1.5, 1, 9999)
+
+	a, b := NewStreamTop(2048, 4, 40), NewStreamTop(2048, 4, 40)
+	exact, exactB := map[string]uint32{}, map[string]uint32{}
+	for i := 0; i < 50000; i++ {
+		k := strconv.FormatUint(zipf.Uint64(), 10)
+		if i%4 == 0 {
+			a.Add(k)
+		} else {
+			b.Add(k)
+			exactB[k]++
+		}
+		exact[k]++
+	}
+
+	check := func(s *StreamTop, exact map[string]uint32) {
+		for _, ib := range s.GetBounds() {
+			if v := exact[ib.Key]; v < ib.Lower || v > ib.Upper {
+				t.Errorf("%v: true count %v outside %v-%v", ib.Key, v, ib.Lower, ib.Upper)
+			}
+		}
+
+		guaranteed := s.GetGuaranteedTop(3)
+		if len(guaranteed) == 0 || len(guaranteed) > 3 {
+			t.Fatalf("Expected 1 to 3 guaranteed items, got %v", guaranteed)
+		}
+		if guaranteed[0].Key != "0" {
+			t.Errorf("Expected 0 to be guaranteed, got %v", guaranteed)
+		}
+	}
+
+	check(b, exactB)
+
+	a.Merge(b)
+	check(a, exact)
+}
+
+func benchmarkStreamTopAdd(b *testing.B, maxItems int, next func(*rand.Rand) uint64) {
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([]string, 1<<18)
+	for i := range keys {
+		keys[i] = strconv.FormatUint(next(rnd), 10)
+	}
+
+	s := NewStreamTop(1<<18, 4, maxItems)
+
+	// Besides the average, report the slowest run of 1024 Adds, which
+	// is where any periodic work would show up.
+	const batch = 1024
+	var worst time.Duration
+	start := time.Now()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Add(keys[i%len(keys)])
+		if i%batch == batch-1 {
+			if d := time.Since(start); d > worst {
+				worst = d
+			}
+			start = time.Now()
+		}
+	}
+
+	b.ReportMetric(float64(worst.Nanoseconds())/batch, "worst-ns/op")
+}
+
+func zipfKeys(rnd *rand.Rand) func(*rand.Rand) uint64 {
+	zipf := rand.NewZipf(rnd, 1.1, 1, 1<<20)
+	return func(*rand.Rand) uint64 { return zipf.Uint64() }
+}
+
+func BenchmarkStreamTopAdd100(b *testing.B) {
+	benchmarkStreamTopAdd(b, 100, zipfKeys(rand.New(rand.NewSource(2))))
+}
+
+func BenchmarkStreamTopAdd10000(b *testing.B) {
+	benchmarkStreamTopAdd(b, 10000, zipfKeys(rand.New(rand.NewSource(2))))
+}
+
+// With keys drawn uniformly from more than maxItems, the tracked set
+// keeps churning.
+func BenchmarkStreamTopChurn(b *testing.B) {
+	benchmarkStreamTopAdd(b, 50000, func(rnd *rand.Rand) uint64 {
+		return uint64(rnd.Intn(200000))
+	})
+}
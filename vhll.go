@@ -0,0 +1,142 @@
+package probably
+
+import (
+	"fmt"
+	"math"
+)
+
+// VirtualHLL estimates the number of distinct elements seen for any
+// key, such as the distinct IPs each user came from, without keeping a
+// HyperLogLog per key.
+//
+// Each row is one shared pool of registers.  A key's elements go into
+// a small virtual HyperLogLog whose registers are scattered across the
+// pool by the key's hash, so they're also written by other keys.  That
+// noise is the same everywhere in the pool, so it's estimated from the
+// whole pool and taken back out.
+//
+// See "Hyper-Compact Virtual Estimators for Big Network Data Based on
+// Register Sharing" (Xiao, Zhou, Chen, 2015).
+type VirtualHLL struct {
+	w, d   int // registers per row, rows
+	s      int // virtual registers per key
+	kComp  int
+	alphaS float64 // for a key's registers
+	alphaW float64 // for a row's registers
+	regs   []uint8
+
+	// For estimating each row as a whole without scanning it.
+	rowSums  []float64 // sum of 2^-register
+	rowZeros []int     // registers still zero
+}
+
+// NewVirtualHLL returns a per-key distinct counter with d rows of w
+// shared registers.  Each key gets as many registers as a HyperLogLog
+// created to count to within the given stderr, which must be well
+// under w for the noise to average out.
+func NewVirtualHLL(w, d int, stdErr float64) *VirtualHLL {
+	if d < 1 || w < 1 {
+		panic("Dimensions must be positive")
+	}
+
+	proto := NewHyperLogLog(stdErr)
+	if int(proto.m) >= w {
+		panic("width must be larger than each key's registers")
+	}
+
+	rv := &VirtualHLL{
+		w:        w,
+		d:        d,
+		s:        int(proto.m),
+		kComp:    proto.kComp,
+		alphaS:   hllAlpha(proto.m),
+		alphaW:   hllAlpha(uint(w)),
+		regs:     make([]uint8, w*d),
+		rowSums:  make([]float64, d),
+		rowZeros: make([]int, d),
+	}
+	for i := 0; i < d; i++ {
+		rv.rowSums[i] = float64(w)
+		rv.rowZeros[i] = w
+	}
+	return rv
+}
+
+func (v *VirtualHLL) String() string {
+	return fmt.Sprintf("{VirtualHLL %dx%d, %d per key}", v.w, v.d, v.s)
+}
+
+// index returns the position in row i of virtual register j.
+func (v *VirtualHLL) index(i, j int, h1, h2 uint32) int {
+	return i*v.w + int((h1+uint32(i*v.s+j)*h2)%uint32(v.w))
+}
+
+// set raises register pos of row i to r, keeping the row totals.
+func (v *VirtualHLL) set(i, pos int, r uint8) {
+	old := v.regs[pos]
+	if r <= old {
+		return
+	}
+	if old == 0 {
+		v.rowZeros[i]--
+	}
+	v.rowSums[i] += math.Ldexp(1, -int(r)) - math.Ldexp(1, -int(old))
+	v.regs[pos] = r
+}
+
+// Add an element, by its hash, for the given key.
+func (v *VirtualHLL) Add(key string, hash uint32) {
+	h1, h2 := hashn(key)
+	j, r := hllRank(v.kComp, hash)
+	for i := 0; i < v.d; i++ {
+		v.set(i, v.index(i, int(j), h1, h2), r)
+	}
+}
+
+// Distinct returns the estimated number of distinct elements added for
+// the given key: the median over the rows of its virtual estimate, less
+// the share of the row's total expected to have landed in its
+// registers by chance.
+func (v *VirtualHLL) Distinct(key string) uint64 {
+	var buf [maxStackDepth]float64
+	vals := buf[:0]
+	if v.d > maxStackDepth {
+		vals = make([]float64, 0, v.d)
+	}
+
+	h1, h2 := hashn(key)
+	s, w := float64(v.s), float64(v.w)
+	for i := 0; i < v.d; i++ {
+		c, zeros := 0.0, 0.0
+		for j := 0; j < v.s; j++ {
+			r := v.regs[v.index(i, j, h1, h2)]
+			c += math.Ldexp(1, -int(r))
+			if r == 0 {
+				zeros++
+			}
+		}
+
+		ns := hllEstimate(s, c, zeros, v.alphaS)
+		nw := hllEstimate(w, v.rowSums[i], float64(v.rowZeros[i]), v.alphaW)
+		e := w * s / (w - s) * (ns/s - nw/w)
+		// negative count doesn't make sense
+		if e < 0 {
+			e = 0
+		}
+		vals = append(vals, e)
+	}
+
+	return uint64(medianf(vals) + 0.5)
+}
+
+// Merge the given counter into this one.  Both must have the same
+// dimensions and registers per key.
+func (v *VirtualHLL) Merge(from *VirtualHLL) {
+	if v.w != from.w || v.d != from.d || v.s != from.s {
+		panic("Can't merge counters of different sizes")
+	}
+
+	for pos, r := range from.regs {
+		v.set(pos/v.w, pos, r)
+	}
+}
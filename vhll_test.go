@@ -0,0 +1,59 @@
+package probably
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestVirtualHLL(t *testing.T) {
+	add := func(v *VirtualHLL, user string, n int) {
+		for i := 0; i < n; i++ {
+			// Each element twice, which shouldn't count.
+			h := elementHash(user + "@" + strconv.Itoa(i))
+			v.Add(user, h)
+			v.Add(user, h)
+		}
+	}
+
+	v := NewVirtualHLL(1<<16, 3, 0.1)
+	for i := 0; i < 5000; i++ {
+		add(v, "user"+strconv.Itoa(i), 10)
+	}
+	tests := []struct {
+		user string
+		n    int
+	}{
+		{"heavy", 5000},
+		{"medium", 1000},
+		{"light", 100},
+	}
+	for _, test := range tests {
+		add(v, test.user, test.n)
+	}
+
+	for _, test := range tests {
+		got := v.Distinct(test.user)
+		if e := abs(float64(got)-float64(test.n)) / float64(test.n); e > 0.3 {
+			t.Errorf("Expected about %v for %v, got %v", test.n, test.user, got)
+		}
+	}
+	if got := v.Distinct("nobody"); got > 50 {
+		t.Errorf("Expected about 0 for nobody, got %v", got)
+	}
+
+	// Splitting the users between two counters and merging them gives
+	// the same registers.
+	a, b := NewVirtualHLL(1<<16, 3, 0.1), NewVirtualHLL(1<<16, 3, 0.1)
+	for i := 0; i < 5000; i++ {
+		add(a, "user"+strconv.Itoa(i), 10)
+	}
+	for _, test := range tests {
+		add(b, test.user, test.n)
+	}
+	a.Merge(b)
+	for _, test := range tests {
+		if got, exp := a.Distinct(test.user), v.Distinct(test.user); got != exp {
+			t.Errorf("Expected %v for %v after merging, got %v", exp, test.user, got)
+		}
+	}
+}
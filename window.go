@@ -0,0 +1,127 @@
+package probably
+
+import (
+	"fmt"
+	"time"
+)
+
+// WindowTop tracks the top items in a sliding window of a stream.
+//
+// Time is divided into panes of fixed duration, each with its own
+// StreamTop, kept in a ring.  As time advances the oldest pane is
+// recycled, so memory is bounded by the number of panes.  Queries
+// merge the panes they cover.
+type WindowTop struct {
+	w, d, maxItems int
+
+	pane    time.Duration
+	panes   []*StreamTop
+	idx     []int64 // which pane each slot holds
+	valid   []bool  // whether each slot holds a pane yet
+	latest  int64   // the latest pane seen
+	started bool    // whether any pane has been seen
+}
+
+// NewWindowTop returns an estimator for the 'maxItems' in a window of
+// 'panes' panes of the given duration.  Each pane uses a count-min
+// sketch created with width w and depth d.
+func NewWindowTop(w, d, maxItems, panes int, pane time.Duration) *WindowTop {
+	if panes < 1 || pane <= 0 {
+		panic("Window must be positive")
+	}
+
+	s := &WindowTop{
+		w:        w,
+		d:        d,
+		maxItems: maxItems,
+		pane:     pane,
+		panes:    make([]*StreamTop, panes),
+		idx:      make([]int64, panes),
+		valid:    make([]bool, panes),
+	}
+	for i := range s.panes {
+		s.panes[i] = NewStreamTop(w, d, maxItems)
+	}
+	return s
+}
+
+func (s *WindowTop) String() string {
+	return fmt.Sprintf("{WindowTop %d x %v}", len(s.panes), s.pane)
+}
+
+// paneIndex returns the pane containing t, rounding down even before
+// the epoch.
+func (s *WindowTop) paneIndex(t time.Time) int64 {
+	ns, p := t.UnixNano(), int64(s.pane)
+	idx := ns / p
+	if ns%p < 0 {
+		idx--
+	}
+	return idx
+}
+
+// slot returns the StreamTop for pane idx, recycling an expired pane
+// if needed, or nil if idx has already fallen out of the window.
+func (s *WindowTop) slot(idx int64) *StreamTop {
+	if !s.started || idx > s.latest {
+		s.latest = idx
+		s.started = true
+	}
+	if s.expired(idx) {
+		return nil
+	}
+
+	n := int64(len(s.panes))
+	i := int((idx%n + n) % n)
+	if !s.valid[i] || s.idx[i] != idx {
+		if !s.panes[i].reset() {
+			s.panes[i] = NewStreamTop(s.w, s.d, s.maxItems)
+		}
+		s.idx[i] = idx
+		s.valid[i] = true
+	}
+	return s.panes[i]
+}
+
+// expired reports whether pane idx has fallen out of the window.
+func (s *WindowTop) expired(idx int64) bool {
+	return idx <= s.latest-int64(len(s.panes))
+}
+
+// AddAt adds an item seen at time t.  Items older than the window are
+// ignored.
+func (s *WindowTop) AddAt(v string, t time.Time) {
+	if st := s.slot(s.paneIndex(t)); st != nil {
+		st.Add(v)
+	}
+}
+
+// TopSince returns the top items seen since time t.  The whole pane
+// containing t is included, and nothing older than the window.
+func (s *WindowTop) TopSince(t time.Time) []ItemCount {
+	since := s.paneIndex(t)
+	rv := NewStreamTop(s.w, s.d, s.maxItems)
+	for i, st := range s.panes {
+		if idx := s.idx[i]; s.valid[i] && idx >= since && !s.expired(idx) {
+			rv.Merge(st)
+		}
+	}
+	return rv.GetTop()
+}
+
+// Merge the given window into this one, pane by pane.  Both must have
+// the same pane duration and count.
+func (s *WindowTop) Merge(from *WindowTop) {
+	if s.pane != from.pane || len(s.panes) != len(from.panes) {
+		panic("Can't merge windows with different panes")
+	}
+
+	for i, st := range from.panes {
+		if !from.valid[i] {
+			continue
+		}
+		if to := s.slot(from.idx[i]); to != nil {
+			to.Merge(st)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package probably
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowTop(t *testing.T) {
+	s := NewWindowTop(1024, 4, 10, 10, time.Minute)
+
+	t0 := time.Unix(1000000*60, 0)
+
+	for i := 0; i < 5; i++ {
+		s.AddAt("old", t0.Add(time.Duration(i)*time.Second))
+	}
+	for i := 0; i < 3; i++ {
+		s.AddAt("new", t0.Add(5*time.Minute))
+	}
+
+	top := s.TopSince(t0)
+	if len(top) != 2 || top[0] != (ItemCount{"old", 5}) || top[1] != (ItemCount{"new", 3}) {
+		t.Errorf("Expected old then new, got %v", top)
+	}
+
+	top = s.TopSince(t0.Add(4 * time.Minute))
+	if len(top) != 1 || top[0] != (ItemCount{"new", 3}) {
+		t.Errorf("Expected just new, got %v", top)
+	}
+
+	// Ten minutes on, the first pane has expired.
+	s.AddAt("newer", t0.Add(10*time.Minute))
+	top = s.TopSince(t0)
+	if len(top) != 2 || top[0] != (ItemCount{"new", 3}) {
+		t.Errorf("Expected old to have expired, got %v", top)
+	}
+
+	// Anything that old is ignored.
+	s.AddAt("old", t0)
+	top = s.TopSince(t0)
+	if len(top) != 2 {
+		t.Errorf("Expected old to be ignored, got %v", top)
+	}
+}
+
+func TestWindowTopBeforeEpoch(t *testing.T) {
+	s := NewWindowTop(1024, 4, 10, 3, time.Minute)
+
+	// Panes -2 and -1, the two minutes before the epoch.
+	s.AddAt("x", time.Unix(-90, 0))
+	s.AddAt("y", time.Unix(-30, 0))
+	s.AddAt("y", time.Unix(-1, 0))
+
+	top := s.TopSince(time.Unix(-120, 0))
+	exp := []ItemCount{{"y", 2}, {"x", 1}}
+	if len(top) != 2 || top[0] != exp[0] || top[1] != exp[1] {
+		t.Errorf("Expected %v, got %v", exp, top)
+	}
+
+	top = s.TopSince(time.Unix(-60, 0))
+	if len(top) != 1 || top[0] != exp[0] {
+		t.Errorf("Expected just y since a minute before the epoch, got %v", top)
+	}
+}
+
+func TestWindowTopMerge(t *testing.T) {
+	a := NewWindowTop(1024, 4, 10, 3, time.Minute)
+	b := NewWindowTop(1024, 4, 10, 3, time.Minute)
+
+	t0 := time.Unix(1000000*60, 0)
+
+	a.AddAt("hello", t0)
+	b.AddAt("hello", t0)
+	b.AddAt("there", t0.Add(time.Minute))
+	b.AddAt("there", t0.Add(time.Minute))
+	b.AddAt("there", t0.Add(time.Minute))
+
+	a.Merge(b)
+
+	top := a.TopSince(t0)
+	exp := []ItemCount{{"there", 3}, {"hello", 2}}
+	if len(top) != 2 || top[0] != exp[0] || top[1] != exp[1] {
+		t.Errorf("Expected %v, got %v", exp, top)
+	}
+}